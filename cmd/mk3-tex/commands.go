@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.defsub.dev/conan/mk3-tex.git/dither"
+	"git.defsub.dev/conan/mk3-tex.git/pack"
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+	"git.defsub.dev/conan/mk3-tex.git/project"
+	"git.defsub.dev/conan/mk3-tex.git/quantize"
+	"git.defsub.dev/conan/mk3-tex.git/txs"
+)
+
+var errUsage = errors.New("wrong usage")
+
+// parseArgs parses flags mixed with positional arguments and returns the positional ones.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		err := fs.Parse(args)
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errUsage
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return positional, nil
+}
+
+// calcFlags override palette calculation options of project file.
+type calcFlags struct {
+	steps     *int
+	attempts  *int
+	timeLimit *string
+	seed      *int64
+	workers   *int
+	progress  *string
+}
+
+func addCalcFlags(fs *flag.FlagSet) *calcFlags {
+	return &calcFlags{
+		steps:     fs.Int("steps", 1000, "maximum palette calculation steps per attempt (overrides #steps)"),
+		attempts:  fs.Int("attempts", 10, "number of palette calculation attempts (overrides #attempts)"),
+		timeLimit: fs.String("timelimit", "", "palette calculation time `limit`, like 90s or 5m (overrides #timelimit)"),
+		seed:      fs.Int64("seed", 0, "random `seed` for palette calculation (overrides #seed)"),
+		workers:   fs.Int("workers", 0, "number of worker goroutines, all CPUs if 0 (overrides #workers)"),
+		progress:  fs.String("progress", "console", "progress and message `output`: console, log or none"),
+	}
+}
+
+func (calc *calcFlags) apply(fs *flag.FlagSet, proj *project.File) error {
+	if isFlagSet(fs, "steps") {
+		proj.Quantize.Steps = *calc.steps
+	}
+	if isFlagSet(fs, "attempts") {
+		proj.Quantize.Attempts = *calc.attempts
+	}
+	if isFlagSet(fs, "timelimit") {
+		limit, err := project.ParseDuration(*calc.timeLimit)
+		if err != nil {
+			return fmt.Errorf("wrong time limit \"%s\": %w", *calc.timeLimit, err)
+		}
+		proj.Quantize.TimeLimit = limit
+	}
+	if isFlagSet(fs, "seed") {
+		proj.Seed = calc.seed
+	}
+	if isFlagSet(fs, "workers") {
+		proj.Workers = *calc.workers
+	}
+	return nil
+}
+
+func (calc *calcFlags) reporter() (quantize.Reporter, error) {
+	return quantize.GetReporter(*calc.progress, os.Stdout)
+}
+
+// calcPalette calculates palette with selected progress output. Calculation
+// is stopped on interrupt.
+func (calc *calcFlags) calcPalette(proj project.File, textures []pack.Texture, reporter quantize.Reporter) (palette.Palette, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return pack.CalcPalette(ctx, proj, textures, reporter)
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mk3-tex %s %s\n\nOptions:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func openProject(filename string) (project.File, error) {
+	proj, err := project.Open(filename)
+	for _, warning := range proj.Warnings {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+	return proj, err
+}
+
+func cmdBuild(args []string) error {
+	fs := newFlagSet("build", "<project> [options]")
+	output := fs.String("o", "result.txs", "output texture pack `file`")
+	palOutput := fs.String("p", "", "also save calculated palette to `file`")
+	palFormat := fs.String("format", "", "palette `format` (json, gpl, act, jasc, raw, png), by extension if empty")
+	legacy := fs.Bool("legacy", false, "write legacy file layout without header")
+	calc := addCalcFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	proj, err := openProject(positional[0])
+	if err != nil {
+		return err
+	}
+	if err := calc.apply(fs, &proj); err != nil {
+		return err
+	}
+	reporter, err := calc.reporter()
+	if err != nil {
+		return err
+	}
+	textures, err := pack.LoadTextures(proj, reporter)
+	if err != nil {
+		return err
+	}
+	pal, err := calc.calcPalette(proj, textures, reporter)
+	if err != nil {
+		return err
+	}
+	if *palOutput != "" {
+		err = savePalette(pal, *palOutput, *palFormat)
+		if err != nil {
+			return err
+		}
+	}
+	file, err := pack.BuildTXS(proj, textures, pal, reporter)
+	if err != nil {
+		return err
+	}
+	if *legacy {
+		file.Version = txs.VersionLegacy
+	}
+	return pack.WriteTextures(*output, file, reporter)
+}
+
+func cmdPalette(args []string) error {
+	fs := newFlagSet("palette", "<project> [options]")
+	output := fs.String("o", "palette.json", "output palette `file`")
+	format := fs.String("format", "", "palette `format` (json, gpl, act, jasc, raw, png), by extension if empty")
+	calc := addCalcFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	proj, err := openProject(positional[0])
+	if err != nil {
+		return err
+	}
+	if err := calc.apply(fs, &proj); err != nil {
+		return err
+	}
+	reporter, err := calc.reporter()
+	if err != nil {
+		return err
+	}
+	textures, err := pack.LoadTextures(proj, reporter)
+	if err != nil {
+		return err
+	}
+	pal, err := calc.calcPalette(proj, textures, reporter)
+	if err != nil {
+		return err
+	}
+	return savePalette(pal, *output, *format)
+}
+
+func savePalette(pal palette.Palette, filename string, format string) error {
+	if format == "" {
+		return pal.Save(filename)
+	}
+	palFormat, err := palette.GetFormat(format)
+	if err != nil {
+		return err
+	}
+	return pal.SaveFormat(filename, palFormat)
+}
+
+func cmdPalConv(args []string) error {
+	fs := newFlagSet("palconv", "<input> <output> [options]")
+	format := fs.String("format", "", "output palette `format` (json, gpl, act, jasc, raw, png), by extension if empty")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	pal, err := palette.Load(positional[0])
+	if err != nil {
+		return err
+	}
+	return savePalette(pal, positional[1], *format)
+}
+
+func cmdConvert(args []string) error {
+	fs := newFlagSet("convert", "-palette <palette.json> [options] <image>")
+	palFile := fs.String("palette", "", "palette `file` to convert with")
+	output := fs.String("o", "", "output image `file` (default <image>_indexed.png)")
+	indexerName := fs.String("indexer", "poster", "indexer `name` (poster, fs, pattern8, pattern4)")
+	workers := fs.Int("workers", 0, "number of worker goroutines, all CPUs if 0")
+	spaceName := fs.String("colorspace", "rgb", "color `space` for distance (rgb, redmean, lab, de2000, oklab)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *palFile == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	indexer, err := dither.GetIndexer(*indexerName)
+	if err != nil {
+		return err
+	}
+	space, err := palette.GetColorSpace(*spaceName)
+	if err != nil {
+		return err
+	}
+	filename := positional[0]
+	outFile := *output
+	if outFile == "" {
+		outFile = strings.TrimSuffix(filename, filepath.Ext(filename)) + "_indexed.png"
+	}
+
+	pal, err := palette.Load(*palFile)
+	if err != nil {
+		return err
+	}
+	data, _, width, height, err := pack.LoadImage(filename)
+	if err != nil {
+		return err
+	}
+	converted, err := pack.ConvertImage(data, nil, width, height, pal, indexer, space, dither.Options{Workers: *workers})
+	if err != nil {
+		return err
+	}
+	return pack.SaveIndexedImage(outFile, converted, width, height, pal)
+}
+
+func cmdCheck(args []string) error {
+	fs := newFlagSet("check", "<project>")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	proj, err := project.Open(positional[0])
+	diags := append(project.Diagnostics{}, proj.Warnings...)
+	if projErrors, ok := err.(project.Diagnostics); ok {
+		diags = append(diags, projErrors...)
+	} else if err != nil {
+		return err
+	}
+	diags = append(diags, pack.CheckTextures(proj)...)
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Line < diags[j].Line })
+
+	errorCount := 0
+	for _, diag := range diags {
+		fmt.Println(diag)
+		if diag.Severity == project.SeverityError {
+			errorCount++
+		}
+	}
+	fmt.Printf("Colors: %d + offset %d = %d of 256\n", proj.Colors, proj.Offset, proj.Colors+proj.Offset)
+	fmt.Printf("Textures: %d, errors: %d, warnings: %d\n", len(proj.Textures), errorCount, len(diags)-errorCount)
+	if errorCount > 0 {
+		return fmt.Errorf("project \"%s\" has %d errors", positional[0], errorCount)
+	}
+	return nil
+}
+
+func cmdInspect(args []string) error {
+	fs := newFlagSet("inspect", "<file.txs> [options]")
+	extract := fs.String("x", "", "extract textures as PNG images into `folder`")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	input, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	file, err := txs.Read(bufio.NewReader(input))
+	if err != nil {
+		return fmt.Errorf("reading \"%s\": %w", positional[0], err)
+	}
+
+	if file.Version == txs.VersionLegacy {
+		fmt.Println("Format: legacy")
+	} else {
+		fmt.Printf("Format: version %d, flags %#04x\n", file.Version, file.Flags)
+	}
+	fmt.Printf("Palette: %d colors, offset %d\n", file.Palette.Len(), file.Offset)
+	fmt.Printf("Textures: %d\n", len(file.Textures))
+	for i, tex := range file.Textures {
+		var used [256]bool
+		unique := 0
+		for _, index := range tex.Data {
+			if !used[index] {
+				used[index] = true
+				unique++
+			}
+		}
+		transparent := "none"
+		if tex.Transparent >= 0 {
+			transparent = strconv.Itoa(tex.Transparent)
+		}
+		fmt.Printf("  %-16s %5d x %-5d transparent %-4s indices used %-3d", tex.Name, tex.Width, tex.Height, transparent, unique)
+		if i < len(file.Directory) {
+			fmt.Printf(" at offset %d", file.Directory[i].Offset)
+		}
+		fmt.Println()
+	}
+
+	if *extract == "" {
+		return nil
+	}
+	err = os.MkdirAll(*extract, 0755)
+	if err != nil {
+		return err
+	}
+	for i := range file.Textures {
+		tex := &file.Textures[i]
+		filename := filepath.Join(*extract, sanitizeFilename(tex.Name)+".png")
+		fmt.Printf("Extracting \"%s\" to \"%s\" ...\n", tex.Name, filename)
+		err = pack.SavePNG(filename, pack.TextureImage(file, tex))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sanitizeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`<>:"/\\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
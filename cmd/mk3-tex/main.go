@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: mk3-tex <command> [arguments]
+
+Commands:
+  build <project> [-o result.txs] [-p palette.json]
+        build texture pack from project file
+  palette <project> [-o palette.json]
+        calculate palette for project file
+  palconv <input> <output>
+        convert palette between file formats
+  convert -palette <palette.json> [-o output.png] <image>
+        convert single image using existing palette
+  check <project>
+        validate project file and textures without building
+  inspect <file.txs> [-x folder]
+        list textures in texture pack and optionally extract them
+
+Run "mk3-tex <command> -h" for command options.
+`)
+}
+
+var commands = map[string]func(args []string) error{
+	"build":   cmdBuild,
+	"palette": cmdPalette,
+	"convert": cmdConvert,
+	"palconv": cmdPalConv,
+	"check":   cmdCheck,
+	"inspect": cmdInspect,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command \"%s\"\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	err := command(os.Args[2:])
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		log.Fatal(err)
+	}
+}
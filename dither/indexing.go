@@ -0,0 +1,282 @@
+package dither
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+var ditherMap = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21}}
+
+var ditherMapSmall = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5}}
+
+// Options tune image indexers.
+type Options struct {
+	// Workers is number of goroutines used by pattern indexers, all CPUs if zero.
+	Workers int
+	// Mask lowers dithering strength of every pixel from full at 0 to none at
+	// 255. Nil dithers all pixels fully.
+	Mask []uint8
+}
+
+func (options Options) workers() int {
+	if options.Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return options.Workers
+}
+
+// strengths returns dithering strength of every pixel from 0 to 1.
+func (options Options) strengths(size int) []float64 {
+	result := make([]float64, size)
+	for i := range result {
+		result[i] = 1
+		if options.Mask != nil {
+			result[i] -= float64(options.Mask[i]) / 255
+		}
+	}
+	return result
+}
+
+// ImageIndexer converts image to indices of matcher palette. Pixels marked in
+// transparent (which may be nil) are skipped and get index -1.
+type ImageIndexer func(imageData []palette.IntColor, transparent []bool, matcher *palette.Matcher, width, height int, options Options) []int
+
+const TransparentIndex = -1
+
+func isTransparent(transparent []bool, index int) bool {
+	return transparent != nil && transparent[index]
+}
+
+func IndexerPosterize(imageData []palette.IntColor, transparent []bool, matcher *palette.Matcher, width, height int, options Options) []int {
+	idata := make([]int, len(imageData))
+	cache := matcher.NewCache()
+	for i := range idata {
+		if isTransparent(transparent, i) {
+			idata[i] = TransparentIndex
+			continue
+		}
+		idata[i] = cache.GetIntColorIndex(imageData[i])
+	}
+	return idata
+}
+
+func addError(dst *palette.FloatColor, err float64) {
+	dst.R = palette.ClipFloat(dst.R + err)
+	dst.G = palette.ClipFloat(dst.G + err)
+	dst.B = palette.ClipFloat(dst.B + err)
+}
+
+func IndexerFS(imageData []palette.IntColor, transparent []bool, matcher *palette.Matcher, width, height int, options Options) []int {
+	data := make([]palette.FloatColor, width*height)
+	idata := make([]int, width*height)
+
+	for i := range data {
+		data[i] = imageData[i].ToFloatColor()
+	}
+	strength := options.strengths(len(data))
+	cache := matcher.NewCache()
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			index := y*width + x
+			if isTransparent(transparent, index) {
+				idata[index] = TransparentIndex
+				continue
+			}
+			oldColor := data[index]
+			newColorIndex := cache.GetFloatColorIndex(oldColor)
+			newColor := matcher.Palette[newColorIndex].ToFloatColor()
+			idata[index] = newColorIndex
+			data[index] = newColor
+			colError := (oldColor.R - newColor.R + oldColor.G - newColor.G + oldColor.B - newColor.B) / 3 * strength[index]
+			if x < width-1 {
+				addError(&data[y*width+x+1], colError*7.0/16.0)
+			}
+			if y < height-1 {
+				if x > 0 {
+					addError(&data[(y+1)*width+x-1], colError*3.0/16.0)
+				}
+				addError(&data[(y+1)*width+x], colError*5.0/16.0)
+				if x < width-1 {
+					addError(&data[(y+1)*width+x+1], colError*1.0/16.0)
+				}
+			}
+		}
+	}
+	return idata
+}
+
+func IndexerPattern8(imageData []palette.IntColor, transparent []bool, matcher *palette.Matcher, width, height int, options Options) []int {
+	//start := time.Now()
+	data := make([]palette.FloatColor, width*height)
+	idata := make([]int, width*height)
+	pattern := make([]int, width*height)
+
+	for i := range data {
+		data[i] = imageData[i].ToFloatColor()
+	}
+
+	index := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pattern[index] = ditherMap[x%8][y%8]
+			index++
+		}
+	}
+
+	var treshold float64 = 0.5
+	var wg sync.WaitGroup
+	workers := options.workers()
+	rangeSize := len(data) / workers
+
+	if transparent == nil {
+		transparent = make([]bool, width*height)
+	}
+	strength := options.strengths(width * height)
+
+	workerFunc := func(wdata []palette.FloatColor, widata []int, wpattern []int, wtransparent []bool, wstrength []float64) {
+		var candidates [8 * 8]int
+		cache := matcher.NewCache()
+		for p := range wdata {
+			if wtransparent[p] {
+				widata[p] = TransparentIndex
+				continue
+			}
+			cerr := palette.FloatColor{}
+			pixelTreshold := treshold * wstrength[p]
+			for i := range candidates {
+				attempt := wdata[p]
+				attempt.R = palette.ClipFloat(attempt.R + cerr.R*pixelTreshold)
+				attempt.G = palette.ClipFloat(attempt.G + cerr.G*pixelTreshold)
+				attempt.B = palette.ClipFloat(attempt.B + cerr.B*pixelTreshold)
+				colorIndex := cache.GetFloatColorIndex(attempt)
+				candidates[i] = colorIndex
+				candidate := matcher.Palette[colorIndex].ToFloatColor()
+				cerr.R += wdata[p].R - candidate.R
+				cerr.G += wdata[p].G - candidate.G
+				cerr.B += wdata[p].B - candidate.B
+			}
+			sort.Ints(candidates[:])
+			widata[p] = candidates[wpattern[p]]
+		}
+		wg.Done()
+	}
+
+	for i := 0; i < workers-1; i++ {
+		rangeStart := i * rangeSize
+		rangeEnd := (i + 1) * rangeSize
+		wg.Add(1)
+		go workerFunc(data[rangeStart:rangeEnd], idata[rangeStart:rangeEnd], pattern[rangeStart:rangeEnd], transparent[rangeStart:rangeEnd], strength[rangeStart:rangeEnd])
+	}
+	rangeStart := (workers - 1) * rangeSize
+	wg.Add(1)
+	go workerFunc(data[rangeStart:], idata[rangeStart:], pattern[rangeStart:], transparent[rangeStart:], strength[rangeStart:])
+
+	wg.Wait()
+
+	//fmt.Printf("PatternMt: %s\n", time.Since(start))
+	return idata
+}
+
+func IndexerPattern4(imageData []palette.IntColor, transparent []bool, matcher *palette.Matcher, width, height int, options Options) []int {
+	//start := time.Now()
+	data := make([]palette.FloatColor, width*height)
+	idata := make([]int, width*height)
+	pattern := make([]int, width*height)
+
+	for i := range data {
+		data[i] = imageData[i].ToFloatColor()
+	}
+
+	index := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pattern[index] = ditherMapSmall[x%4][y%4]
+			index++
+		}
+	}
+
+	var treshold float64 = 0.5
+	var wg sync.WaitGroup
+	workers := options.workers()
+	rangeSize := len(data) / workers
+
+	if transparent == nil {
+		transparent = make([]bool, width*height)
+	}
+	strength := options.strengths(width * height)
+
+	workerFunc := func(wdata []palette.FloatColor, widata []int, wpattern []int, wtransparent []bool, wstrength []float64) {
+		var candidates [4 * 4]int
+		cache := matcher.NewCache()
+		for p := range wdata {
+			if wtransparent[p] {
+				widata[p] = TransparentIndex
+				continue
+			}
+			cerr := palette.FloatColor{}
+			pixelTreshold := treshold * wstrength[p]
+			for i := range candidates {
+				attempt := wdata[p]
+				attempt.R = palette.ClipFloat(attempt.R + cerr.R*pixelTreshold)
+				attempt.G = palette.ClipFloat(attempt.G + cerr.G*pixelTreshold)
+				attempt.B = palette.ClipFloat(attempt.B + cerr.B*pixelTreshold)
+				colorIndex := cache.GetFloatColorIndex(attempt)
+				candidates[i] = colorIndex
+				candidate := matcher.Palette[colorIndex].ToFloatColor()
+				cerr.R += wdata[p].R - candidate.R
+				cerr.G += wdata[p].G - candidate.G
+				cerr.B += wdata[p].B - candidate.B
+			}
+			sort.Ints(candidates[:])
+			widata[p] = candidates[wpattern[p]]
+		}
+		wg.Done()
+	}
+
+	for i := 0; i < workers-1; i++ {
+		rangeStart := i * rangeSize
+		rangeEnd := (i + 1) * rangeSize
+		wg.Add(1)
+		go workerFunc(data[rangeStart:rangeEnd], idata[rangeStart:rangeEnd], pattern[rangeStart:rangeEnd], transparent[rangeStart:rangeEnd], strength[rangeStart:rangeEnd])
+	}
+	rangeStart := (workers - 1) * rangeSize
+	wg.Add(1)
+	go workerFunc(data[rangeStart:], idata[rangeStart:], pattern[rangeStart:], transparent[rangeStart:], strength[rangeStart:])
+
+	wg.Wait()
+
+	//fmt.Printf("PatternMtSmall: %s\n", time.Since(start))
+	return idata
+}
+
+func GetIndexer(name string) (ImageIndexer, error) {
+	switch name {
+	case "poster":
+		return IndexerPosterize, nil
+	case "fs":
+		return IndexerFS, nil
+	case "pattern8":
+		return IndexerPattern8, nil
+	case "pattern4":
+		return IndexerPattern4, nil
+	default:
+		return nil, fmt.Errorf("indexer \"%s\" does not exist", name)
+	}
+}
@@ -0,0 +1,72 @@
+package dither
+
+import (
+	"runtime"
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+func TestPatternWorkers(t *testing.T) {
+	width, height := 37, 23
+	data := make([]palette.IntColor, width*height)
+	transparent := make([]bool, width*height)
+	for i := range data {
+		data[i] = palette.IntColor{R: i * 7 % 256, G: i * 3 % 256, B: i % 256}
+		transparent[i] = i%11 == 0
+	}
+	pal := palette.Palette{}
+	for _, c := range [][3]int{{0, 0, 0}, {255, 255, 255}, {255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {128, 128, 128}} {
+		pal = append(pal, palette.IntColor{R: c[0], G: c[1], B: c[2]})
+	}
+	matcher := palette.NewMatcher(pal, palette.ColorSpaceRGB)
+
+	indexers := map[string]ImageIndexer{"pattern8": IndexerPattern8, "pattern4": IndexerPattern4}
+	for name, indexer := range indexers {
+		want := indexer(data, transparent, matcher, width, height, Options{Workers: 1})
+		for _, workers := range []int{runtime.NumCPU(), 2, 5, 16} {
+			got := indexer(data, transparent, matcher, width, height, Options{Workers: workers})
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%s with %d workers: pixel %d is %d, want %d", name, workers, i, got[i], want[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func benchmarkIndexer(b *testing.B, indexer ImageIndexer, space palette.ColorSpace) {
+	width, height := 256, 256
+	data := make([]palette.IntColor, width*height)
+	for i := range data {
+		// smooth gradients, like textures
+		x, y := i%width, i/width
+		data[i] = palette.IntColor{R: x, G: y, B: (x + y) / 2}
+	}
+	pal := palette.New(256)
+	for i := range pal {
+		pal[i] = palette.IntColor{R: i * 37 % 256, G: i * 101 % 256, B: i * 13 % 256}
+	}
+	matcher := palette.NewMatcher(pal, space)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexer(data, nil, matcher, width, height, Options{Workers: 1})
+	}
+}
+
+func BenchmarkPattern8(b *testing.B) {
+	benchmarkIndexer(b, IndexerPattern8, palette.ColorSpaceRGB)
+}
+
+func BenchmarkPattern8Lab(b *testing.B) {
+	benchmarkIndexer(b, IndexerPattern8, palette.ColorSpaceLab)
+}
+
+func BenchmarkPattern4(b *testing.B) {
+	benchmarkIndexer(b, IndexerPattern4, palette.ColorSpaceRGB)
+}
+
+func BenchmarkFS(b *testing.B) {
+	benchmarkIndexer(b, IndexerFS, palette.ColorSpaceRGB)
+}
@@ -0,0 +1,17 @@
+package pack
+
+import "fmt"
+
+type ImageLoadError struct {
+	Name     string
+	Filename string
+	Err      error
+}
+
+func (e *ImageLoadError) Error() string {
+	return fmt.Sprintf("texture \"%s\" (%s): %v", e.Name, e.Filename, e.Err)
+}
+
+func (e *ImageLoadError) Unwrap() error {
+	return e.Err
+}
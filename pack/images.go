@@ -0,0 +1,138 @@
+package pack
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+
+	_ "image/jpeg"
+
+	"git.defsub.dev/conan/mk3-tex.git/dither"
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+	"git.defsub.dev/conan/mk3-tex.git/txs"
+)
+
+func LoadImage(filename string) ([]palette.IntColor, []uint8, int, int, error) {
+	imgFile, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	defer imgFile.Close()
+	img, _, err := image.Decode(imgFile)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	bounds := img.Bounds()
+	width := bounds.Max.X - bounds.Min.X
+	height := bounds.Max.Y - bounds.Min.Y
+	result := make([]palette.IntColor, 0, width*height)
+	alpha := make([]uint8, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a > 0 && a < 0xffff {
+				// colors are alpha-premultiplied
+				r = r * 0xffff / a
+				g = g * 0xffff / a
+				b = b * 0xffff / a
+			}
+			result = append(result, palette.IntColor{R: int(r / 257), G: int(g / 257), B: int(b / 257)}.Normalized())
+			alpha = append(alpha, uint8(a/257))
+		}
+	}
+	return result, alpha, width, height, nil
+}
+
+// LoadMask loads grayscale mask that must be of given size. Colored images are
+// converted by luma.
+func LoadMask(filename string, width int, height int) ([]uint8, error) {
+	data, _, maskWidth, maskHeight, err := LoadImage(filename)
+	if err != nil {
+		return nil, err
+	}
+	if maskWidth != width || maskHeight != height {
+		return nil, fmt.Errorf("mask is %dx%d, texture is %dx%d", maskWidth, maskHeight, width, height)
+	}
+	mask := make([]uint8, len(data))
+	for i, color := range data {
+		mask[i] = uint8((color.R*299 + color.G*587 + color.B*114 + 500) / 1000)
+	}
+	return mask, nil
+}
+
+func NormalizeAndOffset(image []int, offset int) (result []uint8) {
+	result = make([]uint8, len(image))
+	for i, pixel := range image {
+		pixel += offset
+		if pixel < 0 {
+			pixel = 0
+		}
+		if pixel > 255 {
+			pixel = 255
+		}
+		result[i] = uint8(pixel)
+	}
+	return
+}
+
+func ConvertImage(inputImage []palette.IntColor, transparent []bool, width int, height int, source any, indexer dither.ImageIndexer, space palette.ColorSpace, options dither.Options) ([]int, error) {
+	var pal palette.Palette
+	switch palt := source.(type) {
+	case palette.Palette:
+		pal = palt
+	case string:
+		var err error
+		pal, err = palette.Load(palt)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("wrong palette type %T", source)
+	}
+	if len(pal) == 0 {
+		return nil, fmt.Errorf("palette is empty")
+	}
+
+	return indexer(inputImage, transparent, palette.NewMatcher(pal, space), width, height, options), nil
+}
+
+func SaveIndexedImage(filename string, indices []int, width int, height int, pal palette.Palette) error {
+	colors := make(color.Palette, len(pal))
+	for i, c := range pal {
+		colors[i] = color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, width, height), colors)
+	for i, index := range indices {
+		img.Pix[i] = uint8(index)
+	}
+	return SavePNG(filename, img)
+}
+
+func TextureImage(file *txs.File, tex *txs.Texture) *image.Paletted {
+	colors := make(color.Palette, 256)
+	for i := range colors {
+		colors[i] = color.RGBA{0, 0, 0, 255}
+	}
+	for i, c := range file.Palette {
+		if i+file.Offset < len(colors) {
+			colors[i+file.Offset] = color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255}
+		}
+	}
+	if tex.Transparent >= 0 && tex.Transparent < len(colors) {
+		colors[tex.Transparent] = color.RGBA{0, 0, 0, 0}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, tex.Width, tex.Height), colors)
+	copy(img.Pix, tex.Data)
+	return img
+}
+
+func SavePNG(filename string, img image.Image) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, img)
+}
@@ -0,0 +1,399 @@
+package pack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"git.defsub.dev/conan/mk3-tex.git/dither"
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+	"git.defsub.dev/conan/mk3-tex.git/project"
+	"git.defsub.dev/conan/mk3-tex.git/quantize"
+	"git.defsub.dev/conan/mk3-tex.git/txs"
+)
+
+type Texture struct {
+	Data  []palette.IntColor
+	Alpha []uint8
+	// Mask is importance of every pixel, nil if texture has no mask.
+	Mask   []uint8
+	Width  int
+	Height int
+	Name   string
+}
+
+// TransparentMask marks pixels that get the reserved transparent index: pixels with
+// alpha below the project threshold, pixels of the key color and pixels of the same
+// color as the one picked by texture coordinates. Returns nil if there are none.
+func (tex *Texture) TransparentMask(proj *project.File, entry *project.TextureEntry) []bool {
+	if !proj.HasReservedIndex() {
+		return nil
+	}
+	keys := make([]palette.IntColor, 0, 2)
+	if proj.KeyColor != nil {
+		keys = append(keys, *proj.KeyColor)
+	}
+	if entry.HasTransparency && checkTransparentPixel(entry, tex.Width, tex.Height) == nil {
+		keys = append(keys, tex.Data[entry.TransparentX+entry.TransparentY*tex.Width])
+	}
+
+	var mask []bool
+	for i, color := range tex.Data {
+		transparent := proj.AlphaThreshold > 0 && int(tex.Alpha[i]) < proj.AlphaThreshold
+		for _, key := range keys {
+			if color == key {
+				transparent = true
+			}
+		}
+		if transparent {
+			if mask == nil {
+				mask = make([]bool, len(tex.Data))
+			}
+			mask[i] = true
+		}
+	}
+	return mask
+}
+
+// message sends text to reporter, console if nil.
+func message(reporter quantize.Reporter, format string, args ...any) {
+	if reporter == nil {
+		reporter = &quantize.ConsoleReporter{Output: os.Stdout}
+	}
+	reporter.Message(fmt.Sprintf(format, args...))
+}
+
+// LoadTextures loads images of project textures, reporting to reporter
+// (console if nil). Textures follow proj.Textures, so on any error all of
+// them are tried, but only the joined errors are returned.
+func LoadTextures(proj project.File, reporter quantize.Reporter) ([]Texture, error) {
+	textures := make([]Texture, 0, len(proj.Textures))
+	errs := make([]error, 0)
+	for _, entry := range proj.Textures {
+		message(reporter, "Loading \"%s\" as \"%s\" ...", filepath.Base(entry.Filename), entry.Name)
+		data, alpha, width, height, err := LoadImage(entry.Filename)
+		if err != nil {
+			errs = append(errs, &ImageLoadError{entry.Name, entry.Filename, err})
+			continue
+		}
+		var mask []uint8
+		if entry.MaskFilename != "" {
+			mask, err = LoadMask(entry.MaskFilename, width, height)
+			if err != nil {
+				errs = append(errs, &ImageLoadError{entry.Name, entry.MaskFilename, err})
+				continue
+			}
+		}
+		textures = append(textures, Texture{
+			Data:   data,
+			Alpha:  alpha,
+			Mask:   mask,
+			Width:  width,
+			Height: height,
+			Name:   entry.Name,
+		})
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return textures, nil
+}
+
+// CalcPalette calculates palette for textures, reporting progress to reporter
+// (console if nil). It can be stopped with ctx.
+func CalcPalette(ctx context.Context, proj project.File, textures []Texture, reporter quantize.Reporter) (palette.Palette, error) {
+	var base palette.Palette
+	if proj.PaletteFile != "" {
+		var err error
+		message(reporter, "Loading palette \"%s\" ...", filepath.Base(proj.PaletteFile))
+		base, err = palette.Load(proj.PaletteFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(base) > proj.Colors {
+			return nil, fmt.Errorf("palette \"%s\" has %d colors, only %d allowed", proj.PaletteFile, len(base), proj.Colors)
+		}
+		if proj.PaletteMode == project.PaletteFull {
+			return loadedPalette(&proj, base)
+		}
+	}
+
+	colors := proj.Colors
+	if proj.HasReservedIndex() {
+		colors--
+	}
+
+	imgdata := make([][]palette.IntColor, 0, len(textures))
+	masks := make([][]uint8, 0, len(textures))
+	for i := range textures {
+		tex := &textures[i]
+		mask := tex.TransparentMask(&proj, &proj.Textures[i])
+		if mask == nil {
+			imgdata = append(imgdata, tex.Data)
+			masks = append(masks, tex.Mask)
+			continue
+		}
+		opaque := make([]palette.IntColor, 0, len(tex.Data))
+		var importance []uint8
+		if tex.Mask != nil {
+			importance = make([]uint8, 0, len(tex.Data))
+		}
+		for i, color := range tex.Data {
+			if !mask[i] {
+				opaque = append(opaque, color)
+				if tex.Mask != nil {
+					importance = append(importance, tex.Mask[i])
+				}
+			}
+		}
+		imgdata = append(imgdata, opaque)
+		masks = append(masks, importance)
+	}
+
+	message(reporter, "Calculating palette...")
+	settings := proj.Quantize
+	settings.Workers = proj.Workers
+	settings.Reporter = reporter
+	quantizer := quantize.New(proj.Quantizer, colors, settings)
+	quantizer.SetFixed(fixedColors(&proj, base))
+	quantizer.SetColorSpace(proj.ColorSpace)
+	quantizer.SetWeights(textureWeights(&proj, imgdata))
+	quantizer.SetMasks(masks)
+	if proj.Seed != nil {
+		quantizer.SetSeed(*proj.Seed)
+	}
+	err := quantizer.Input(imgdata)
+	if err != nil {
+		return nil, err
+	}
+	err = quantizer.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pal := quantizer.GetPalette()
+	if proj.HasReservedIndex() {
+		pal = insertColor(pal, proj.TransparentIndex, proj.TransparentColor())
+	}
+	return pal, nil
+}
+
+// textureWeights returns weights of textures for palette calculation. With area
+// normalization smaller textures are scaled up to the largest one.
+func textureWeights(proj *project.File, imgdata [][]palette.IntColor) []float64 {
+	maxArea := 0
+	for _, data := range imgdata {
+		if len(data) > maxArea {
+			maxArea = len(data)
+		}
+	}
+	weights := make([]float64, len(imgdata))
+	for i, data := range imgdata {
+		weights[i] = proj.Textures[i].Weight
+		if proj.NormalizeArea && len(data) > 0 {
+			weights[i] *= float64(maxArea) / float64(len(data))
+		}
+	}
+	return weights
+}
+
+// loadedPalette uses loaded palette as is, only applying fixed colors.
+func loadedPalette(proj *project.File, base palette.Palette) (palette.Palette, error) {
+	result := append(palette.Palette{}, base...)
+	for _, fixed := range proj.FixedColors {
+		if fixed.Index >= len(result) {
+			return nil, fmt.Errorf("fixed color index %d is outside of loaded palette (%d colors)", fixed.Index, len(result))
+		}
+		result[fixed.Index] = fixed.Color
+	}
+	if proj.HasReservedIndex() {
+		if proj.TransparentIndex >= len(result) {
+			return nil, fmt.Errorf("transparent index %d is outside of loaded palette (%d colors)", proj.TransparentIndex, len(result))
+		}
+		if proj.KeyColor != nil {
+			result[proj.TransparentIndex] = *proj.KeyColor
+		}
+	}
+	return result, nil
+}
+
+// fixedColors converts project fixed colors and colors of partially loaded palette
+// to indices of palette without reserved slot.
+func fixedColors(proj *project.File, base palette.Palette) []quantize.FixedColor {
+	all := append([]project.FixedColor{}, proj.FixedColors...)
+	for i, color := range base {
+		taken := proj.HasReservedIndex() && i == proj.TransparentIndex
+		for _, fixed := range proj.FixedColors {
+			if fixed.Index == i {
+				taken = true
+			}
+		}
+		if !taken {
+			all = append(all, project.FixedColor{Index: i, Color: color})
+		}
+	}
+
+	result := make([]quantize.FixedColor, 0, len(all))
+	for _, fixed := range all {
+		index := fixed.Index
+		if proj.HasReservedIndex() && index > proj.TransparentIndex {
+			index--
+		}
+		result = append(result, quantize.FixedColor{Index: index, Color: fixed.Color})
+	}
+	return result
+}
+
+func insertColor(pal palette.Palette, index int, color palette.IntColor) palette.Palette {
+	for len(pal) < index {
+		// not enough colors in images to fill palette up to reserved index
+		pal = append(pal, palette.IntColor{})
+	}
+	result := make(palette.Palette, 0, len(pal)+1)
+	result = append(result, pal[:index]...)
+	result = append(result, color)
+	return append(result, pal[index:]...)
+}
+
+// BuildTXS indexes textures with palette, reporting to reporter (console if nil).
+func BuildTXS(proj project.File, textures []Texture, pal palette.Palette, reporter quantize.Reporter) (*txs.File, error) {
+	result := &txs.File{
+		Version:  txs.Version,
+		Palette:  pal,
+		Offset:   proj.Offset,
+		Textures: make([]txs.Texture, 0, len(textures)),
+	}
+	reserved := proj.TransparentIndex
+	opaquePal := pal
+	if proj.HasReservedIndex() {
+		if reserved >= len(pal) {
+			return nil, fmt.Errorf("reserved transparent index %d is outside of palette (%d colors)", reserved, len(pal))
+		}
+		// indexers never see the reserved color
+		opaquePal = append(append(palette.Palette{}, pal[:reserved]...), pal[reserved+1:]...)
+	}
+	for i, tex := range textures {
+		message(reporter, "Adding \"%s\" ...", tex.Name)
+		entry := &proj.Textures[i]
+		if err := checkTransparentPixel(entry, tex.Width, tex.Height); err != nil {
+			return nil, fmt.Errorf("texture \"%s\": %w", tex.Name, err)
+		}
+		mask := tex.TransparentMask(&proj, entry)
+		options := dither.Options{Workers: proj.Workers, Mask: ditherMask(&proj, &tex)}
+		indices, err := ConvertImage(tex.Data, mask, tex.Width, tex.Height, opaquePal, proj.Indexer, proj.ColorSpace, options)
+		if err != nil {
+			return nil, fmt.Errorf("texture \"%s\": %w", tex.Name, err)
+		}
+		if proj.HasReservedIndex() {
+			for j, index := range indices {
+				if index == dither.TransparentIndex {
+					indices[j] = reserved
+				} else if index >= reserved {
+					indices[j] = index + 1
+				}
+			}
+		}
+		converted := NormalizeAndOffset(indices, proj.Offset)
+		transparent := -1
+		if proj.HasReservedIndex() {
+			if mask != nil || entry.HasTransparency {
+				transparent = proj.Offset + reserved
+			}
+		} else if entry.HasTransparency {
+			transparent = int(converted[entry.TransparentX+entry.TransparentY*tex.Width])
+		}
+		result.Textures = append(result.Textures, txs.Texture{
+			Name:        tex.Name,
+			Width:       tex.Width,
+			Height:      tex.Height,
+			Transparent: transparent,
+			Data:        converted,
+		})
+	}
+	return result, nil
+}
+
+// ditherMask scales texture mask by project mask dithering amount, nil if
+// dithering is not affected.
+func ditherMask(proj *project.File, tex *Texture) []uint8 {
+	if tex.Mask == nil || proj.MaskDither <= 0 {
+		return nil
+	}
+	result := make([]uint8, len(tex.Mask))
+	for i, value := range tex.Mask {
+		result[i] = uint8(float64(value)*proj.MaskDither + 0.5)
+	}
+	return result
+}
+
+func WriteTextures(filename string, pack *txs.File, reporter quantize.Reporter) error {
+	message(reporter, "Saving file...")
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = txs.Write(file, pack)
+	if err != nil {
+		file.Close()
+		os.Remove(filename)
+		return fmt.Errorf("writing \"%s\": %w", filename, err)
+	}
+	return nil
+}
+
+func checkTransparentPixel(entry *project.TextureEntry, width int, height int) error {
+	if !entry.HasTransparency {
+		return nil
+	}
+	if entry.TransparentX >= width || entry.TransparentY >= height {
+		return fmt.Errorf("transparent pixel (%d, %d) is outside of image (%dx%d)", entry.TransparentX, entry.TransparentY, width, height)
+	}
+	return nil
+}
+
+// CheckTextures verifies that every texture of the project can be loaded without building it.
+func CheckTextures(proj project.File) project.Diagnostics {
+	result := make(project.Diagnostics, 0)
+	if proj.PaletteFile != "" {
+		if _, err := palette.Load(proj.PaletteFile); err != nil {
+			result = append(result, project.Diagnostic{
+				Severity: project.SeverityError,
+				File:     proj.Filename,
+				Msg:      err.Error(),
+			})
+		}
+	}
+	fail := func(entry *project.TextureEntry, err error) {
+		result = append(result, project.Diagnostic{
+			Severity: project.SeverityError,
+			File:     proj.Filename,
+			Line:     entry.Line,
+			Text:     entry.Name,
+			Msg:      err.Error(),
+		})
+	}
+	for i := range proj.Textures {
+		entry := &proj.Textures[i]
+		if _, err := os.Stat(entry.Filename); err != nil {
+			fail(entry, err)
+			continue
+		}
+		_, _, width, height, err := LoadImage(entry.Filename)
+		if err != nil {
+			fail(entry, fmt.Errorf("can not decode \"%s\": %w", entry.Filename, err))
+			continue
+		}
+		if err := checkTransparentPixel(entry, width, height); err != nil {
+			fail(entry, err)
+		}
+		if entry.MaskFilename != "" {
+			if _, err := LoadMask(entry.MaskFilename, width, height); err != nil {
+				fail(entry, fmt.Errorf("can not use mask \"%s\": %w", entry.MaskFilename, err))
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package pack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+	"git.defsub.dev/conan/mk3-tex.git/project"
+	"git.defsub.dev/conan/mk3-tex.git/quantize"
+	"git.defsub.dev/conan/mk3-tex.git/txs"
+)
+
+// openProject writes project file with given commands next to test assets.
+func openProject(t *testing.T, text string) project.File {
+	assets, err := filepath.Abs(filepath.Join("..", "test_assets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "project.txt")
+	text += "a \"" + filepath.Join(assets, "gatox01.png") + "\"\n" +
+		"b \"" + filepath.Join(assets, "gatox02a.png") + "\" 0 0\n"
+	if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proj, err := project.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proj
+}
+
+// build returns encoded palette and texture pack.
+func build(t *testing.T, proj project.File) ([]byte, []byte) {
+	reporter, _ := quantize.GetReporter("none", nil)
+	textures, err := LoadTextures(proj, reporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pal, err := CalcPalette(context.Background(), proj, textures, reporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := BuildTXS(proj, textures, pal, reporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack bytes.Buffer
+	if err := txs.Write(&pack, file); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := palette.Encode(pal, palette.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded, pack.Bytes()
+}
+
+func TestSeedReproducible(t *testing.T) {
+	for _, method := range []string{"kmeans", "mediancut", "octree", "wu", "wukmeans"} {
+		proj := openProject(t, "#colors 16\n#colorbits 5\n#seed 42\n#steps 10\n#attempts 2\n#indexer fs\n#quantizer "+method+"\n")
+		pal1, pack1 := build(t, proj)
+		proj.Workers = 3
+		pal2, pack2 := build(t, proj)
+		if !bytes.Equal(pal1, pal2) {
+			t.Errorf("%s: palettes differ", method)
+		}
+		if !bytes.Equal(pack1, pack2) {
+			t.Errorf("%s: packs differ", method)
+		}
+	}
+}
+
+func TestLoadTexturesErrors(t *testing.T) {
+	proj := openProject(t, "")
+	proj.Textures = append(proj.Textures[:1], project.TextureEntry{Name: "missing", Filename: "missing.png"}, proj.Textures[1])
+	reporter, _ := quantize.GetReporter("none", nil)
+	textures, err := LoadTextures(proj, reporter)
+	var loadErr *ImageLoadError
+	if !errors.As(err, &loadErr) || loadErr.Name != "missing" {
+		t.Fatalf("got error %v, want ImageLoadError of \"missing\"", err)
+	}
+	if textures != nil {
+		t.Errorf("got %d textures with error", len(textures))
+	}
+}
@@ -0,0 +1,132 @@
+package pack
+
+import (
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/dither"
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+	"git.defsub.dev/conan/mk3-tex.git/project"
+	"git.defsub.dev/conan/mk3-tex.git/quantize"
+)
+
+var (
+	magenta     = palette.IntColor{R: 255, B: 255}
+	nearMagenta = palette.IntColor{R: 250, G: 5, B: 250}
+)
+
+// testPalette has magenta at reserved index and 7 other colors around it.
+func testPalette(reserved int) palette.Palette {
+	opaque := [][3]int{{0, 0, 0}, {255, 255, 255}, {200, 30, 30}, {30, 200, 30}, {30, 30, 200}, {128, 128, 0}, {240, 10, 160}}
+	pal := make(palette.Palette, 0, len(opaque)+1)
+	for _, c := range opaque {
+		if len(pal) == reserved {
+			pal = append(pal, magenta)
+		}
+		pal = append(pal, palette.IntColor{R: c[0], G: c[1], B: c[2]})
+	}
+	if len(pal) == reserved {
+		pal = append(pal, magenta)
+	}
+	return pal
+}
+
+// testTexture uses every color of pal, magenta and a color near magenta.
+func testTexture(pal palette.Palette) Texture {
+	width, height := 8, 8
+	tex := Texture{
+		Data:   make([]palette.IntColor, width*height),
+		Alpha:  make([]uint8, width*height),
+		Width:  width,
+		Height: height,
+		Name:   "test",
+	}
+	colors := append(append(palette.Palette{}, pal...), nearMagenta)
+	for i := range tex.Data {
+		tex.Data[i] = colors[i*5%len(colors)]
+		tex.Alpha[i] = 255
+		if i%7 == 3 {
+			tex.Alpha[i] = 10
+		}
+	}
+	return tex
+}
+
+func TestBuildTXSTransparency(t *testing.T) {
+	reporter, _ := quantize.GetReporter("none", nil)
+	indexers := []string{"poster", "fs", "pattern8", "pattern4"}
+	tests := []struct {
+		name  string
+		key   bool
+		pick  bool
+		alpha int
+	}{
+		{"key color", true, false, 0},
+		{"picked pixel", false, true, 0},
+		{"alpha", false, false, project.DefaultAlphaThreshold},
+		{"all", true, true, project.DefaultAlphaThreshold},
+	}
+	for _, test := range tests {
+		for _, name := range indexers {
+			for _, reserved := range []int{0, 3, 7} {
+				for _, offset := range []int{0, 10} {
+					indexer, _ := dither.GetIndexer(name)
+					pal := testPalette(reserved)
+					tex := testTexture(pal)
+					proj := project.File{
+						Colors:           len(pal),
+						Offset:           offset,
+						Indexer:          indexer,
+						ColorSpace:       palette.ColorSpaceRGB,
+						Workers:          1,
+						AlphaThreshold:   test.alpha,
+						TransparentIndex: reserved,
+						Textures:         []project.TextureEntry{{Name: tex.Name}},
+					}
+					if test.key {
+						proj.KeyColor = &magenta
+					}
+					// picked pixel has the first opaque palette color
+					picked := pal[0]
+					if reserved == 0 {
+						picked = pal[1]
+					}
+					if test.pick {
+						entry := &proj.Textures[0]
+						entry.HasTransparency = true
+						for i, color := range tex.Data {
+							if color == picked {
+								entry.TransparentX, entry.TransparentY = i%tex.Width, i/tex.Width
+								break
+							}
+						}
+					}
+					file, err := BuildTXS(proj, []Texture{tex}, pal, reporter)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got := file.Textures[0]
+					where := test.name + ", " + name
+					if got.Transparent != offset+reserved {
+						t.Errorf("%s, reserved %d, offset %d: transparent index %d, want %d", where, reserved, offset, got.Transparent, offset+reserved)
+					}
+					for i, index := range got.Data {
+						color := tex.Data[i]
+						transparent := (test.key && color == magenta) || (test.pick && color == picked) ||
+							(test.alpha > 0 && int(tex.Alpha[i]) < test.alpha)
+						switch {
+						case transparent && int(index) != offset+reserved:
+							t.Errorf("%s, reserved %d, offset %d: transparent pixel %d has index %d", where, reserved, offset, i, index)
+						case !transparent && int(index) == offset+reserved:
+							t.Errorf("%s, reserved %d, offset %d: opaque pixel %d %v has reserved index", where, reserved, offset, i, color)
+						case !transparent && (int(index) < offset || int(index) >= offset+len(pal)):
+							t.Errorf("%s, reserved %d, offset %d: opaque pixel %d has index %d outside of palette", where, reserved, offset, i, index)
+						case !transparent && name == "poster" && color != magenta && color != nearMagenta && pal[int(index)-offset] != color:
+							// exact colors must keep their index around the reserved slot
+							t.Errorf("%s, reserved %d, offset %d: pixel %d %v has index %d of %v", where, reserved, offset, i, color, index, pal[int(index)-offset])
+						}
+					}
+				}
+			}
+		}
+	}
+}
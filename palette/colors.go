@@ -1,9 +1,8 @@
-package main
+package palette
 
 import (
-	"encoding/json"
+	"fmt"
 	"math"
-	"os"
 	"sort"
 )
 
@@ -19,7 +18,7 @@ type Palette []IntColor
 
 //===== FLOAT COLOR =======
 
-func clipFloat(val float64) float64 {
+func ClipFloat(val float64) float64 {
 	if val > 1.0 {
 		return 1.0
 	}
@@ -31,9 +30,9 @@ func clipFloat(val float64) float64 {
 
 func (color FloatColor) Normalized() FloatColor {
 	return FloatColor{
-		clipFloat(color.R),
-		clipFloat(color.G),
-		clipFloat(color.B)}
+		ClipFloat(color.R),
+		ClipFloat(color.G),
+		ClipFloat(color.B)}
 }
 
 func (color FloatColor) Distance(other FloatColor) float64 {
@@ -42,14 +41,15 @@ func (color FloatColor) Distance(other FloatColor) float64 {
 		(color.B-other.B)*(color.B-other.B)
 }
 
+// ToIntColor rounds color to the nearest integer color.
 func (color FloatColor) ToIntColor() IntColor {
 	norm := color.Normalized()
-	return IntColor{int(norm.R * 255), int(norm.G * 255), int(norm.B * 255)}
+	return IntColor{int(norm.R*255 + 0.5), int(norm.G*255 + 0.5), int(norm.B*255 + 0.5)}
 }
 
 //===== INT COLOR =======
 
-func clipInt(val int) int {
+func ClipInt(val int) int {
 	if val > 255 {
 		return 255
 	}
@@ -61,9 +61,9 @@ func clipInt(val int) int {
 
 func (color IntColor) Normalized() IntColor {
 	return IntColor{
-		clipInt(color.R),
-		clipInt(color.G),
-		clipInt(color.B)}
+		ClipInt(color.R),
+		ClipInt(color.G),
+		ClipInt(color.B)}
 }
 
 func (color IntColor) Distance(other IntColor) uint64 {
@@ -82,7 +82,7 @@ func (color IntColor) ToFloatColor() FloatColor {
 
 //===== PALETTE =======
 
-func NewPalette(colors int) Palette {
+func New(colors int) Palette {
 	return make([]IntColor, colors)
 }
 
@@ -102,23 +102,6 @@ func (pal Palette) Sort() {
 	sort.Sort(pal)
 }
 
-func (pal *Palette) Save(filename string) {
-	pal.Sort()
-	fo, err := os.Create(filename)
-	if err != nil {
-		panic(err)
-	}
-	defer fo.Close()
-	data, err := json.MarshalIndent(pal, "", "    ")
-	if err != nil {
-		panic(err)
-	}
-	_, err = fo.Write(data)
-	if err != nil {
-		panic(err)
-	}
-}
-
 func (pal Palette) GetIntColorIndex(color IntColor) (index int) {
 	var mindist uint64 = math.MaxUint64
 	index = 0
@@ -136,15 +119,15 @@ func (pal Palette) GetFloatColorIndex(color FloatColor) (index int) {
 	return pal.GetIntColorIndex(color.ToIntColor())
 }
 
-func PaletteLoad(filename string) Palette {
-	fi, err := os.ReadFile(filename)
-	if err != nil {
-		panic(err)
-	}
-	result := &Palette{}
-	err = json.Unmarshal(fi, result)
-	if err != nil {
-		panic(err)
-	}
-	return *result
+type FileError struct {
+	Filename string
+	Err      error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("palette \"%s\": %v", e.Filename, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
 }
@@ -0,0 +1,342 @@
+package palette
+
+import (
+	"fmt"
+	"math"
+)
+
+// ColorSpace defines how color distance is measured. Colors are converted into
+// the working space with Convert, distances are squared and compared there.
+type ColorSpace int
+
+const (
+	// ColorSpaceRGB is plain euclidean distance in sRGB.
+	ColorSpaceRGB ColorSpace = iota
+	// ColorSpaceRedmean is sRGB distance weighted by mean red ("redmean").
+	ColorSpaceRedmean
+	// ColorSpaceLab is CIE76 ΔE, euclidean distance in CIELAB.
+	ColorSpaceLab
+	// ColorSpaceLab2000 is CIEDE2000 ΔE in CIELAB.
+	ColorSpaceLab2000
+	// ColorSpaceOKLab is euclidean distance in OKLab.
+	ColorSpaceOKLab
+)
+
+var colorSpaceNames = []string{"rgb", "redmean", "lab", "de2000", "oklab"}
+
+func GetColorSpace(name string) (ColorSpace, error) {
+	for i, spaceName := range colorSpaceNames {
+		if spaceName == name {
+			return ColorSpace(i), nil
+		}
+	}
+	return ColorSpaceRGB, fmt.Errorf("color space \"%s\" does not exist", name)
+}
+
+func (space ColorSpace) String() string {
+	if int(space) < len(colorSpaceNames) {
+		return colorSpaceNames[space]
+	}
+	return fmt.Sprintf("ColorSpace(%d)", int(space))
+}
+
+// Convert maps sRGB color (components 0..1) into the working space.
+func (space ColorSpace) Convert(color FloatColor) FloatColor {
+	switch space {
+	case ColorSpaceLab, ColorSpaceLab2000:
+		return rgbToLab(color)
+	case ColorSpaceOKLab:
+		return rgbToOKLab(color)
+	default:
+		return color
+	}
+}
+
+// Revert maps color from the working space back to sRGB.
+func (space ColorSpace) Revert(color FloatColor) FloatColor {
+	switch space {
+	case ColorSpaceLab, ColorSpaceLab2000:
+		return labToRGB(color)
+	case ColorSpaceOKLab:
+		return okLabToRGB(color)
+	default:
+		return color
+	}
+}
+
+// Distance returns squared distance between two converted colors.
+func (space ColorSpace) Distance(a, b FloatColor) float64 {
+	switch space {
+	case ColorSpaceRedmean:
+		rmean := (a.R + b.R) / 2
+		dr := a.R - b.R
+		dg := a.G - b.G
+		db := a.B - b.B
+		return (2+rmean)*dr*dr + 4*dg*dg + (3-rmean)*db*db
+	case ColorSpaceLab2000:
+		return deltaE2000(a, b)
+	default:
+		return a.Distance(b)
+	}
+}
+
+//===== CIELAB =======
+
+const (
+	labEpsilon = 216.0 / 24389.0
+	labKappa   = 24389.0 / 27.0
+
+	// D65 white point
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+func toLinear(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func fromLinear(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+func labF(t float64) float64 {
+	if t > labEpsilon {
+		return math.Cbrt(t)
+	}
+	return (labKappa*t + 16) / 116
+}
+
+func labFInv(f float64) float64 {
+	if f3 := f * f * f; f3 > labEpsilon {
+		return f3
+	}
+	return (116*f - 16) / labKappa
+}
+
+// rgbToLab returns L, a, b in R, G, B fields.
+func rgbToLab(color FloatColor) FloatColor {
+	r := toLinear(color.R)
+	g := toLinear(color.G)
+	b := toLinear(color.B)
+	fx := labF((0.4124564*r + 0.3575761*g + 0.1804375*b) / whiteX)
+	fy := labF((0.2126729*r + 0.7151522*g + 0.0721750*b) / whiteY)
+	fz := labF((0.0193339*r + 0.1191920*g + 0.9503041*b) / whiteZ)
+	return FloatColor{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+func labToRGB(color FloatColor) FloatColor {
+	fy := (color.R + 16) / 116
+	x := labFInv(fy+color.G/500) * whiteX
+	y := labFInv(fy) * whiteY
+	z := labFInv(fy-color.B/200) * whiteZ
+	return FloatColor{
+		fromLinear(ClipFloat(3.2404542*x - 1.5371385*y - 0.4985314*z)),
+		fromLinear(ClipFloat(-0.9692660*x + 1.8760108*y + 0.0415560*z)),
+		fromLinear(ClipFloat(0.0556434*x - 0.2040259*y + 1.0572252*z))}
+}
+
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func sinDeg(deg float64) float64 {
+	return math.Sin(deg * math.Pi / 180)
+}
+
+func cosDeg(deg float64) float64 {
+	return math.Cos(deg * math.Pi / 180)
+}
+
+// deltaE2000 returns squared CIEDE2000 difference of two CIELAB colors.
+func deltaE2000(lab1, lab2 FloatColor) float64 {
+	const pow25to7 = 6103515625.0
+
+	c1 := math.Hypot(lab1.G, lab1.B)
+	c2 := math.Hypot(lab2.G, lab2.B)
+	c7 := math.Pow((c1+c2)/2, 7)
+	g := 0.5 * (1 - math.Sqrt(c7/(c7+pow25to7)))
+	a1 := lab1.G * (1 + g)
+	a2 := lab2.G * (1 + g)
+	c1p := math.Hypot(a1, lab1.B)
+	c2p := math.Hypot(a2, lab2.B)
+	h1p := hueAngle(lab1.B, a1)
+	h2p := hueAngle(lab2.B, a2)
+
+	dL := lab2.R - lab1.R
+	dC := c2p - c1p
+	dh := 0.0
+	hMean := h1p + h2p
+	if c1p*c2p != 0 {
+		dh = h2p - h1p
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+		if math.Abs(h1p-h2p) > 180 {
+			if hMean < 360 {
+				hMean += 360
+			} else {
+				hMean -= 360
+			}
+		}
+		hMean /= 2
+	}
+	dH := 2 * math.Sqrt(c1p*c2p) * sinDeg(dh/2)
+
+	lMean := (lab1.R + lab2.R) / 2
+	cMean := (c1p + c2p) / 2
+	t := 1 - 0.17*cosDeg(hMean-30) + 0.24*cosDeg(2*hMean) + 0.32*cosDeg(3*hMean+6) - 0.20*cosDeg(4*hMean-63)
+	dTheta := 30 * math.Exp(-((hMean-275)/25)*((hMean-275)/25))
+	cMean7 := math.Pow(cMean, 7)
+	rc := 2 * math.Sqrt(cMean7/(cMean7+pow25to7))
+	l50 := (lMean - 50) * (lMean - 50)
+	sl := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sc := 1 + 0.045*cMean
+	sh := 1 + 0.015*cMean*t
+	rt := -sinDeg(2*dTheta) * rc
+
+	tl := dL / sl
+	tc := dC / sc
+	th := dH / sh
+	return tl*tl + tc*tc + th*th + rt*tc*th
+}
+
+//===== OKLAB =======
+
+// rgbToOKLab returns L, a, b in R, G, B fields.
+func rgbToOKLab(color FloatColor) FloatColor {
+	r := toLinear(color.R)
+	g := toLinear(color.G)
+	b := toLinear(color.B)
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	return FloatColor{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s}
+}
+
+func okLabToRGB(color FloatColor) FloatColor {
+	l := color.R + 0.3963377774*color.G + 0.2158037573*color.B
+	m := color.R - 0.1055613458*color.G - 0.0638541728*color.B
+	s := color.R - 0.0894841775*color.G - 1.2914855480*color.B
+	l, m, s = l*l*l, m*m*m, s*s*s
+	return FloatColor{
+		fromLinear(ClipFloat(4.0767416621*l - 3.3077115913*m + 0.2309699292*s)),
+		fromLinear(ClipFloat(-1.2684380046*l + 2.6097574011*m - 0.3413193965*s)),
+		fromLinear(ClipFloat(-0.0041960863*l - 0.7034186147*m + 1.7076147010*s))}
+}
+
+//===== MATCHER =======
+
+// Matcher finds nearest palette colors using given color space. RGB is
+// searched with color grid, other euclidean spaces with k-d tree and the rest
+// with linear scan.
+type Matcher struct {
+	Palette Palette
+	Space   ColorSpace
+
+	converted []FloatColor
+	tree      *kdTree
+	grid      *colorGrid
+}
+
+func NewMatcher(pal Palette, space ColorSpace) *Matcher {
+	converted := make([]FloatColor, len(pal))
+	for i, c := range pal {
+		converted[i] = space.Convert(c.ToFloatColor())
+	}
+	m := &Matcher{Palette: pal, Space: space, converted: converted}
+	switch space {
+	case ColorSpaceRGB:
+		if len(pal) <= 256 {
+			m.grid = newColorGrid(pal)
+		}
+	case ColorSpaceLab, ColorSpaceOKLab:
+		m.tree = newKDTree(converted)
+	}
+	return m
+}
+
+func (m *Matcher) GetIntColorIndex(color IntColor) int {
+	switch {
+	case len(m.Palette) == 0:
+		return 0
+	case m.grid != nil && color == color.Normalized():
+		return m.grid.nearest(color)
+	case m.Space == ColorSpaceRGB:
+		return m.Palette.GetIntColorIndex(color)
+	case m.tree == nil:
+		return m.nearest(m.Space.Convert(color.ToFloatColor()))
+	default:
+		return m.tree.nearest(m.Space.Convert(color.ToFloatColor()))
+	}
+}
+
+func (m *Matcher) GetFloatColorIndex(color FloatColor) int {
+	return m.GetIntColorIndex(color.ToIntColor())
+}
+
+// nearest is linear scan over converted palette.
+func (m *Matcher) nearest(color FloatColor) (index int) {
+	mindist := math.MaxFloat64
+	for i, c := range m.converted {
+		dist := m.Space.Distance(color, c)
+		if dist < mindist {
+			mindist = dist
+			index = i
+		}
+	}
+	return
+}
+
+const matchCacheBits = 14
+
+// MatchCache remembers recent matches of a matcher. Dithering looks up nearly
+// the same colors many times, so most lookups are answered without search. It
+// is not safe for concurrent use, every goroutine needs its own cache.
+type MatchCache struct {
+	matcher *Matcher
+	// keys are packed colors plus one, zero marks empty slot
+	keys    [1 << matchCacheBits]uint32
+	indices [1 << matchCacheBits]int32
+}
+
+func (m *Matcher) NewCache() *MatchCache {
+	return &MatchCache{matcher: m}
+}
+
+func (cache *MatchCache) GetIntColorIndex(color IntColor) int {
+	if color != color.Normalized() {
+		return cache.matcher.GetIntColorIndex(color)
+	}
+	key := uint32(color.R)<<16 | uint32(color.G)<<8 | uint32(color.B) + 1
+	slot := key * 2654435761 >> (32 - matchCacheBits)
+	if cache.keys[slot] == key {
+		return int(cache.indices[slot])
+	}
+	index := cache.matcher.GetIntColorIndex(color)
+	cache.keys[slot] = key
+	cache.indices[slot] = int32(index)
+	return index
+}
+
+func (cache *MatchCache) GetFloatColorIndex(color FloatColor) int {
+	return cache.GetIntColorIndex(color.ToIntColor())
+}
@@ -0,0 +1,425 @@
+package palette
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Format int
+
+const (
+	FormatJSON Format = iota
+	FormatGPL
+	FormatACT
+	FormatJASC
+	FormatRaw
+	FormatPNG
+)
+
+var formatNames = map[string]Format{
+	"json": FormatJSON,
+	"gpl":  FormatGPL,
+	"act":  FormatACT,
+	"jasc": FormatJASC,
+	"raw":  FormatRaw,
+	"png":  FormatPNG,
+}
+
+var formatExtensions = map[string]Format{
+	".json":    FormatJSON,
+	".gpl":     FormatGPL,
+	".act":     FormatACT,
+	".pal":     FormatJASC,
+	".lmp":     FormatRaw,
+	".raw":     FormatRaw,
+	".playpal": FormatRaw,
+	".png":     FormatPNG,
+}
+
+const (
+	rawPaletteSize = 256 * 3
+	swatchColumns  = 16
+	swatchCell     = 8
+)
+
+func GetFormat(name string) (Format, error) {
+	format, ok := formatNames[strings.ToLower(name)]
+	if !ok {
+		return FormatJSON, fmt.Errorf("palette format \"%s\" does not exist", name)
+	}
+	return format, nil
+}
+
+// FormatFromFilename guesses format by file extension, defaulting to JSON.
+func FormatFromFilename(filename string) Format {
+	if format, ok := formatExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		return format
+	}
+	if strings.EqualFold(filepath.Base(filename), "playpal") {
+		return FormatRaw
+	}
+	return FormatJSON
+}
+
+// DetectFormat guesses format by file contents. Binary extensions are trusted
+// as is, text signatures are sniffed only for other files.
+func DetectFormat(filename string, data []byte) Format {
+	trimmed := bytes.TrimLeft(data, " \t\r\n\ufeff")
+	format := FormatFromFilename(filename)
+	switch format {
+	case FormatACT, FormatRaw:
+		return format
+	case FormatJASC:
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("JASC-PAL")):
+			return FormatJASC
+		case bytes.HasPrefix(trimmed, []byte("GIMP Palette")):
+			return FormatGPL
+		case len(data) > 0 && len(data)%3 == 0:
+			// binary .pal files are raw palettes
+			return FormatRaw
+		}
+		return format
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		return FormatPNG
+	case bytes.HasPrefix(trimmed, []byte("GIMP Palette")):
+		return FormatGPL
+	case bytes.HasPrefix(trimmed, []byte("JASC-PAL")):
+		return FormatJASC
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return FormatJSON
+	}
+	return format
+}
+
+// Decode reads palette of given format. Palettes with more than 256 colors or
+// components outside of 0..255 are rejected.
+func Decode(data []byte, format Format) (Palette, error) {
+	result, err := decode(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return result, checkPalette(result)
+}
+
+func decode(data []byte, format Format) (Palette, error) {
+	switch format {
+	case FormatJSON:
+		result := Palette{}
+		err := json.Unmarshal(data, &result)
+		return result, err
+	case FormatGPL:
+		return decodeGPL(data)
+	case FormatACT:
+		return decodeACT(data)
+	case FormatJASC:
+		return decodeJASC(data)
+	case FormatRaw:
+		return decodeRaw(data)
+	case FormatPNG:
+		return decodePNG(data)
+	default:
+		return nil, fmt.Errorf("unknown palette format %d", format)
+	}
+}
+
+func Encode(pal Palette, format Format) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		return json.MarshalIndent(pal, "", "    ")
+	case FormatGPL:
+		return encodeGPL(pal), nil
+	case FormatACT:
+		return encodeACT(pal)
+	case FormatJASC:
+		return encodeJASC(pal), nil
+	case FormatRaw:
+		return encodeRaw(pal)
+	case FormatPNG:
+		return encodePNG(pal)
+	default:
+		return nil, fmt.Errorf("unknown palette format %d", format)
+	}
+}
+
+func Load(filename string) (Palette, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, &FileError{filename, err}
+	}
+	result, err := Decode(data, DetectFormat(filename, data))
+	if err != nil {
+		return nil, &FileError{filename, err}
+	}
+	if len(result) == 0 {
+		return nil, &FileError{filename, errors.New("palette is empty")}
+	}
+	return result, nil
+}
+
+// Save writes palette in format chosen by file extension.
+func (pal Palette) Save(filename string) error {
+	return pal.SaveFormat(filename, FormatFromFilename(filename))
+}
+
+func (pal Palette) SaveFormat(filename string, format Format) error {
+	data, err := Encode(pal, format)
+	if err != nil {
+		return &FileError{filename, err}
+	}
+	err = os.WriteFile(filename, data, 0644)
+	if err != nil {
+		return &FileError{filename, err}
+	}
+	return nil
+}
+
+// checkPalette verifies that palette fits into 256 colors of 8-bit components.
+func checkPalette(pal Palette) error {
+	if len(pal) > 256 {
+		return fmt.Errorf("too many colors (%d)", len(pal))
+	}
+	for i, c := range pal {
+		if c.R < 0 || c.R > 255 || c.G < 0 || c.G > 255 || c.B < 0 || c.B > 255 {
+			return fmt.Errorf("color %d (%d, %d, %d) is out of 0..255", i, c.R, c.G, c.B)
+		}
+	}
+	return nil
+}
+
+// parseRGB parses three color components at the start of fields.
+func parseRGB(fields []string) (IntColor, error) {
+	if len(fields) < 3 {
+		return IntColor{}, fmt.Errorf("not enough color components")
+	}
+	var rgb [3]int
+	for i := range rgb {
+		value, err := strconv.Atoi(fields[i])
+		if err != nil || value < 0 || value > 255 {
+			return IntColor{}, fmt.Errorf("wrong color component \"%s\"", fields[i])
+		}
+		rgb[i] = value
+	}
+	return IntColor{rgb[0], rgb[1], rgb[2]}, nil
+}
+
+//===== GIMP =======
+
+func decodeGPL(data []byte) (Palette, error) {
+	result := Palette{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			if !strings.HasPrefix(strings.TrimPrefix(text, "\ufeff"), "GIMP Palette") {
+				return nil, errors.New("missing \"GIMP Palette\" header")
+			}
+			continue
+		}
+		if text == "" || text[0] == '#' || strings.HasPrefix(text, "Name:") || strings.HasPrefix(text, "Columns:") {
+			continue
+		}
+		color, err := parseRGB(strings.Fields(text))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		result = append(result, color)
+	}
+	return result, scanner.Err()
+}
+
+func encodeGPL(pal Palette) []byte {
+	var result bytes.Buffer
+	fmt.Fprintf(&result, "GIMP Palette\nName: mk3-tex\nColumns: %d\n#\n", swatchColumns)
+	for i, c := range pal {
+		fmt.Fprintf(&result, "%3d %3d %3d\tIndex %d\n", c.R, c.G, c.B, i)
+	}
+	return result.Bytes()
+}
+
+//===== ADOBE COLOR TABLE =======
+
+func decodeACT(data []byte) (Palette, error) {
+	if len(data) != rawPaletteSize && len(data) != rawPaletteSize+4 {
+		return nil, fmt.Errorf("wrong ACT file size %d", len(data))
+	}
+	count := 256
+	if len(data) == rawPaletteSize+4 {
+		stored := int(binary.BigEndian.Uint16(data[rawPaletteSize:]))
+		if stored > 0 && stored <= 256 {
+			count = stored
+		}
+	}
+	return decodeTriplets(data[:count*3]), nil
+}
+
+func encodeACT(pal Palette) ([]byte, error) {
+	result, err := encodeRaw(pal)
+	if err != nil {
+		return nil, err
+	}
+	result = binary.BigEndian.AppendUint16(result, uint16(len(pal)))
+	// no transparent color
+	return binary.BigEndian.AppendUint16(result, 0xFFFF), nil
+}
+
+//===== JASC =======
+
+func decodeJASC(data []byte) (Palette, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	header := make([]string, 0, 3)
+	for len(header) < 3 && scanner.Scan() {
+		header = append(header, strings.TrimSpace(scanner.Text()))
+	}
+	if len(header) < 3 || strings.TrimPrefix(header[0], "\ufeff") != "JASC-PAL" {
+		return nil, errors.New("missing \"JASC-PAL\" header")
+	}
+	count, err := strconv.Atoi(header[2])
+	if err != nil || count < 0 || count > 256 {
+		return nil, fmt.Errorf("wrong number of colors \"%s\"", header[2])
+	}
+
+	result := make(Palette, 0, count)
+	for len(result) < count && scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		color, err := parseRGB(strings.Fields(text))
+		if err != nil {
+			return nil, fmt.Errorf("color %d: %w", len(result), err)
+		}
+		result = append(result, color)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(result) < count {
+		return nil, fmt.Errorf("expected %d colors, found %d", count, len(result))
+	}
+	return result, nil
+}
+
+func encodeJASC(pal Palette) []byte {
+	var result bytes.Buffer
+	fmt.Fprintf(&result, "JASC-PAL\r\n0100\r\n%d\r\n", len(pal))
+	for _, c := range pal {
+		fmt.Fprintf(&result, "%d %d %d\r\n", c.R, c.G, c.B)
+	}
+	return result.Bytes()
+}
+
+//===== RAW / PLAYPAL =======
+
+func decodeTriplets(data []byte) Palette {
+	result := New(len(data) / 3)
+	for i := range result {
+		result[i] = IntColor{int(data[i*3]), int(data[i*3+1]), int(data[i*3+2])}
+	}
+	return result
+}
+
+// decodeRaw reads 256 RGB triplets. Longer files like PLAYPAL contain several
+// palettes, only the first one is used.
+func decodeRaw(data []byte) (Palette, error) {
+	if len(data) >= rawPaletteSize {
+		return decodeTriplets(data[:rawPaletteSize]), nil
+	}
+	if len(data) == 0 || len(data)%3 != 0 {
+		return nil, fmt.Errorf("wrong raw palette size %d", len(data))
+	}
+	return decodeTriplets(data), nil
+}
+
+func encodeRaw(pal Palette) ([]byte, error) {
+	if len(pal) > 256 {
+		return nil, fmt.Errorf("too many colors (%d)", len(pal))
+	}
+	result := make([]byte, rawPaletteSize)
+	for i, c := range pal {
+		c = c.Normalized()
+		result[i*3] = uint8(c.R)
+		result[i*3+1] = uint8(c.G)
+		result[i*3+2] = uint8(c.B)
+	}
+	return result, nil
+}
+
+//===== PNG SWATCH =======
+
+// decodePNG uses palette of indexed images, otherwise collects distinct colors
+// in the order they appear.
+func decodePNG(data []byte) (Palette, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if paletted, ok := img.(*image.Paletted); ok {
+		result := New(len(paletted.Palette))
+		for i, c := range paletted.Palette {
+			r, g, b, _ := c.RGBA()
+			result[i] = IntColor{int(r >> 8), int(g >> 8), int(b >> 8)}
+		}
+		return result, nil
+	}
+
+	result := Palette{}
+	seen := make(map[IntColor]struct{})
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			c := IntColor{int(r >> 8), int(g >> 8), int(b >> 8)}
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			if len(result) == 256 {
+				return nil, errors.New("swatch image has more than 256 colors")
+			}
+			seen[c] = struct{}{}
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func encodePNG(pal Palette) ([]byte, error) {
+	if len(pal) == 0 || len(pal) > 256 {
+		return nil, fmt.Errorf("wrong number of colors (%d)", len(pal))
+	}
+	colors := make(color.Palette, len(pal))
+	for i, c := range pal {
+		c = c.Normalized()
+		colors[i] = color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255}
+	}
+	rows := (len(pal) + swatchColumns - 1) / swatchColumns
+	img := image.NewPaletted(image.Rect(0, 0, swatchColumns*swatchCell, rows*swatchCell), colors)
+	for y := 0; y < rows*swatchCell; y++ {
+		for x := 0; x < swatchColumns*swatchCell; x++ {
+			index := y/swatchCell*swatchColumns + x/swatchCell
+			if index >= len(pal) {
+				// fill the rest of the last row with the last color
+				index = len(pal) - 1
+			}
+			img.Pix[y*img.Stride+x] = uint8(index)
+		}
+	}
+	var result bytes.Buffer
+	err := png.Encode(&result, img)
+	return result.Bytes(), err
+}
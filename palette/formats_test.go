@@ -0,0 +1,97 @@
+package palette
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatsRoundTrip(t *testing.T) {
+	pals := map[string]Palette{
+		// first bytes look like JSON or text to content sniffing
+		"bracket": {{91, 0, 0}, {1, 2, 3}},
+		"space":   {{32, 91, 10}, {13, 9, 255}},
+		"gimp":    {{'G', 'I', 'M'}, {'P', ' ', 'P'}},
+		"full":    New(256),
+	}
+	for i := range pals["full"] {
+		pals["full"][i] = IntColor{i, 255 - i, i / 2}
+	}
+	names := []string{"x.json", "x.gpl", "x.act", "x.pal", "x.lmp", "x.raw", "x.playpal", "playpal", "x.png"}
+	dir := t.TempDir()
+	for palName, pal := range pals {
+		for _, name := range names {
+			filename := filepath.Join(dir, palName+"-"+name)
+			if err := pal.Save(filename); err != nil {
+				t.Fatalf("%s: %v", filename, err)
+			}
+			loaded, err := Load(filename)
+			if err != nil {
+				t.Errorf("%s: %v", filename, err)
+				continue
+			}
+			if FormatFromFilename(filename) == FormatRaw {
+				// raw palettes always have 256 colors
+				for _, c := range loaded[len(pal):] {
+					if c != (IntColor{}) {
+						t.Errorf("%s: padding color %v", filename, c)
+					}
+				}
+				loaded = loaded[:len(pal)]
+			}
+			if len(loaded) != len(pal) {
+				t.Errorf("%s: loaded %d colors, want %d", filename, len(loaded), len(pal))
+				continue
+			}
+			for i := range pal {
+				if loaded[i] != pal[i] {
+					t.Errorf("%s: color %d is %v, want %v", filename, i, loaded[i], pal[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     string
+		want     Format
+	}{
+		{"x.pal", "JASC-PAL\r\n0100\r\n1\r\n1 2 3\r\n", FormatJASC},
+		{"x.pal", "GIMP Palette\n1 2 3\n", FormatGPL},
+		{"x.pal", "[\x00\x00", FormatRaw},
+		{"x.act", "[{}]", FormatACT},
+		{"x.txt", " [{\"R\":1}]", FormatJSON},
+		{"x.txt", "\ufeffGIMP Palette\n", FormatGPL},
+		{"x", "\x89PNG", FormatPNG},
+	}
+	for _, test := range tests {
+		if got := DetectFormat(test.filename, []byte(test.data)); got != test.want {
+			t.Errorf("DetectFormat(%q, %q) = %d, want %d", test.filename, test.data, got, test.want)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tooMany := "GIMP Palette\n"
+	for i := 0; i < 257; i++ {
+		tooMany += "1 2 3\n"
+	}
+	tests := []struct {
+		data   string
+		format Format
+	}{
+		{`[{"R":300,"G":-5,"B":1000}]`, FormatJSON},
+		{`[{"R":0,"G":0,"B":256}]`, FormatJSON},
+		{`[{"R":-1,"G":0,"B":0}]`, FormatJSON},
+		{"[" + strings.Repeat(`{"R":1,"G":2,"B":3},`, 256) + `{"R":1,"G":2,"B":3}]`, FormatJSON},
+		{tooMany, FormatGPL},
+	}
+	for _, test := range tests {
+		if _, err := Decode([]byte(test.data), test.format); err == nil {
+			t.Errorf("Decode(%.40q): no error", test.data)
+		}
+	}
+}
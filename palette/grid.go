@@ -0,0 +1,91 @@
+package palette
+
+import (
+	"sync/atomic"
+)
+
+const (
+	gridBits  = 5
+	gridShift = 8 - gridBits
+	gridSize  = 1 << gridBits
+)
+
+// colorGrid finds nearest color by RGB distance. Color cube is split into
+// cells, every cell keeps palette colors that may be the nearest for some of
+// its colors, so lookup scans only a few candidates. Cells are filled on first
+// use and may be filled concurrently.
+type colorGrid struct {
+	palette Palette
+	cells   [gridSize * gridSize * gridSize]atomic.Pointer[[]uint8]
+}
+
+func newColorGrid(pal Palette) *colorGrid {
+	return &colorGrid{palette: pal}
+}
+
+// axisDistances returns squared distances from value to the nearest and the
+// farthest point of the cell range.
+func axisDistances(value int, low int, high int) (uint64, uint64) {
+	var near, far int
+	switch {
+	case value < low:
+		near, far = low-value, high-value
+	case value > high:
+		near, far = value-high, value-low
+	default:
+		far = value - low
+		if high-value > far {
+			far = high - value
+		}
+	}
+	return uint64(near * near), uint64(far * far)
+}
+
+// candidates lists palette colors whose distance to the cell does not exceed
+// the smallest distance that surely covers the whole cell. Equally near
+// colors are kept, in index order, so the lowest index still wins ties.
+func (grid *colorGrid) candidates(r, g, b int) []uint8 {
+	low := [3]int{r << gridShift, g << gridShift, b << gridShift}
+	nears := make([]uint64, len(grid.palette))
+	bound := ^uint64(0)
+	for i, c := range grid.palette {
+		var near, far uint64
+		for a, value := range [3]int{c.R, c.G, c.B} {
+			n, f := axisDistances(value, low[a], low[a]+1<<gridShift-1)
+			near += n
+			far += f
+		}
+		nears[i] = near
+		if far < bound {
+			bound = far
+		}
+	}
+	result := make([]uint8, 0)
+	for i, near := range nears {
+		if near <= bound {
+			result = append(result, uint8(i))
+		}
+	}
+	return result
+}
+
+func (grid *colorGrid) nearest(color IntColor) int {
+	r, g, b := color.R>>gridShift, color.G>>gridShift, color.B>>gridShift
+	cell := &grid.cells[(r*gridSize+g)*gridSize+b]
+	candidates := cell.Load()
+	if candidates == nil {
+		list := grid.candidates(r, g, b)
+		cell.Store(&list)
+		candidates = &list
+	}
+	best := 0
+	bestDist := ^uint64(0)
+	for _, i := range *candidates {
+		dist := color.Distance(grid.palette[i])
+		if dist < bestDist {
+			bestDist = dist
+			best = int(i)
+		}
+	}
+	return best
+}
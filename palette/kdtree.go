@@ -0,0 +1,95 @@
+package palette
+
+import (
+	"math"
+	"sort"
+)
+
+// kdTree finds nearest color by squared euclidean distance. Among equally near
+// colors the one with the lowest index wins, the same as linear scan does.
+type kdTree struct {
+	nodes []kdNode
+	root  int
+}
+
+type kdNode struct {
+	coords [3]float64
+	index  int
+	axis   int
+	left   int
+	right  int
+}
+
+func newKDTree(colors []FloatColor) *kdTree {
+	tree := &kdTree{nodes: make([]kdNode, len(colors))}
+	order := make([]int, len(colors))
+	for i, c := range colors {
+		tree.nodes[i] = kdNode{coords: [3]float64{c.R, c.G, c.B}, index: i, left: -1, right: -1}
+		order[i] = i
+	}
+	tree.root = tree.build(order)
+	return tree
+}
+
+// build splits nodes by the axis with largest spread and returns median node.
+func (tree *kdTree) build(order []int) int {
+	if len(order) == 0 {
+		return -1
+	}
+	axis := 0
+	maxSpread := -1.0
+	for a := 0; a < 3; a++ {
+		low := math.MaxFloat64
+		high := -math.MaxFloat64
+		for _, i := range order {
+			low = math.Min(low, tree.nodes[i].coords[a])
+			high = math.Max(high, tree.nodes[i].coords[a])
+		}
+		if high-low > maxSpread {
+			maxSpread = high - low
+			axis = a
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return tree.nodes[order[i]].coords[axis] < tree.nodes[order[j]].coords[axis]
+	})
+	mid := len(order) / 2
+	node := &tree.nodes[order[mid]]
+	node.axis = axis
+	node.left = tree.build(order[:mid])
+	node.right = tree.build(order[mid+1:])
+	return order[mid]
+}
+
+func (tree *kdTree) nearest(color FloatColor) int {
+	best := -1
+	bestDist := math.MaxFloat64
+	tree.search(tree.root, [3]float64{color.R, color.G, color.B}, &best, &bestDist)
+	return best
+}
+
+func (tree *kdTree) search(node int, query [3]float64, best *int, bestDist *float64) {
+	if node < 0 {
+		return
+	}
+	n := &tree.nodes[node]
+	dr := query[0] - n.coords[0]
+	dg := query[1] - n.coords[1]
+	db := query[2] - n.coords[2]
+	dist := dr*dr + dg*dg + db*db
+	if dist < *bestDist || (dist == *bestDist && n.index < *best) {
+		*best = n.index
+		*bestDist = dist
+	}
+
+	diff := query[n.axis] - n.coords[n.axis]
+	near, far := n.left, n.right
+	if diff > 0 {
+		near, far = far, near
+	}
+	tree.search(near, query, best, bestDist)
+	// equal distances are still searched to keep the lowest index
+	if diff*diff <= *bestDist {
+		tree.search(far, query, best, bestDist)
+	}
+}
@@ -0,0 +1,135 @@
+package palette
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// gridPalette has duplicates and colors on a coarse grid, so that many
+// colors are equally near to several palette colors.
+func gridPalette(rng *rand.Rand, size int) Palette {
+	pal := New(size)
+	for i := range pal {
+		pal[i] = IntColor{R: rng.Intn(9) * 32, G: rng.Intn(9) * 32, B: rng.Intn(9) * 32}
+	}
+	return pal
+}
+
+func randomColors(rng *rand.Rand, count int) []IntColor {
+	colors := make([]IntColor, count)
+	for i := range colors {
+		if i%2 == 0 {
+			// halfway between grid points
+			colors[i] = IntColor{R: rng.Intn(17) * 16, G: rng.Intn(17) * 16, B: rng.Intn(17) * 16}
+		} else {
+			colors[i] = IntColor{R: rng.Intn(256), G: rng.Intn(256), B: rng.Intn(256)}
+		}
+	}
+	return colors
+}
+
+func intCoords(pal Palette) []FloatColor {
+	coords := make([]FloatColor, len(pal))
+	for i, c := range pal {
+		coords[i] = FloatColor{float64(c.R), float64(c.G), float64(c.B)}
+	}
+	return coords
+}
+
+func TestMatcherKDTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, space := range []ColorSpace{ColorSpaceRGB, ColorSpaceLab, ColorSpaceOKLab} {
+		for _, size := range []int{1, 2, 16, 256} {
+			pal := gridPalette(rng, size)
+			m := NewMatcher(pal, space)
+			tree := m.tree
+			if space == ColorSpaceRGB {
+				if m.grid == nil {
+					t.Fatalf("%s: matcher has no grid", space)
+				}
+				tree = newKDTree(intCoords(pal))
+			}
+			if tree == nil {
+				t.Fatalf("%s: matcher has no tree", space)
+			}
+			for _, color := range randomColors(rng, 2000) {
+				want := m.nearest(space.Convert(color.ToFloatColor()))
+				treeIndex := tree.nearest(space.Convert(color.ToFloatColor()))
+				if space == ColorSpaceRGB {
+					want = pal.GetIntColorIndex(color)
+					treeIndex = tree.nearest(intCoords(Palette{color})[0])
+				}
+				if got := m.GetIntColorIndex(color); got != want {
+					t.Errorf("%s, %d colors: %v matched %d %v, want %d %v", space, size, color, got, pal[got], want, pal[want])
+				}
+				if treeIndex != want {
+					t.Errorf("%s, %d colors: tree matched %v to %d %v, want %d %v", space, size, color, treeIndex, pal[treeIndex], want, pal[want])
+				}
+			}
+		}
+	}
+}
+
+func TestMatcherGridAllColors(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	pal := gridPalette(rng, 64)
+	pal = append(pal, randomColors(rng, 64)...)
+	m := NewMatcher(pal, ColorSpaceRGB)
+	for r := 0; r < 256; r += 3 {
+		for g := 0; g < 256; g += 5 {
+			for b := 0; b < 256; b++ {
+				color := IntColor{R: r, G: g, B: b}
+				if got, want := m.GetIntColorIndex(color), pal.GetIntColorIndex(color); got != want {
+					t.Fatalf("%v matched %d %v, want %d %v", color, got, pal[got], want, pal[want])
+				}
+			}
+		}
+	}
+}
+
+func benchmarkMatcher(b *testing.B, space ColorSpace, find func(m *Matcher, color IntColor) int) {
+	rng := rand.New(rand.NewSource(1))
+	pal := New(256)
+	for i := range pal {
+		pal[i] = IntColor{R: rng.Intn(256), G: rng.Intn(256), B: rng.Intn(256)}
+	}
+	colors := randomColors(rng, 4096)
+	m := NewMatcher(pal, space)
+	if m.tree == nil {
+		m.tree = newKDTree(intCoords(pal))
+	}
+	// fill grid cells
+	for _, color := range colors {
+		m.GetIntColorIndex(color)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		find(m, colors[i%len(colors)])
+	}
+}
+
+func BenchmarkMatcherScan(b *testing.B) {
+	benchmarkMatcher(b, ColorSpaceRGB, func(m *Matcher, color IntColor) int {
+		return m.Palette.GetIntColorIndex(color)
+	})
+}
+
+func BenchmarkMatcherKDTree(b *testing.B) {
+	benchmarkMatcher(b, ColorSpaceRGB, func(m *Matcher, color IntColor) int {
+		return m.tree.nearest(FloatColor{float64(color.R), float64(color.G), float64(color.B)})
+	})
+}
+
+func BenchmarkMatcherGrid(b *testing.B) {
+	benchmarkMatcher(b, ColorSpaceRGB, (*Matcher).GetIntColorIndex)
+}
+
+func BenchmarkMatcherScanLab(b *testing.B) {
+	benchmarkMatcher(b, ColorSpaceLab, func(m *Matcher, color IntColor) int {
+		return m.nearest(m.Space.Convert(color.ToFloatColor()))
+	})
+}
+
+func BenchmarkMatcherKDTreeLab(b *testing.B) {
+	benchmarkMatcher(b, ColorSpaceLab, (*Matcher).GetIntColorIndex)
+}
@@ -0,0 +1,52 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+type Diagnostic struct {
+	Severity Severity
+	File     string
+	Line     int
+	Column   int
+	Text     string
+	Msg      string
+}
+
+func (d Diagnostic) Error() string {
+	var result strings.Builder
+	result.WriteString(d.File)
+	if d.Line > 0 {
+		fmt.Fprintf(&result, ":%d", d.Line)
+		if d.Column > 0 {
+			fmt.Fprintf(&result, ":%d", d.Column)
+		}
+	}
+	result.WriteString(": ")
+	if d.Severity == SeverityWarning {
+		result.WriteString("warning: ")
+	}
+	result.WriteString(d.Msg)
+	if d.Text != "" {
+		fmt.Fprintf(&result, " (%q)", d.Text)
+	}
+	return result.String()
+}
+
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := make([]string, len(d))
+	for i, diag := range d {
+		lines[i] = diag.Error()
+	}
+	return strings.Join(lines, "\n")
+}
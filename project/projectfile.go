@@ -0,0 +1,603 @@
+package project
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defsub.dev/conan/mk3-tex.git/dither"
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+	"git.defsub.dev/conan/mk3-tex.git/quantize"
+	"github.com/google/shlex"
+)
+
+const MaxNameLength = 16
+
+type TextureEntry struct {
+	Name            string
+	Filename        string
+	HasTransparency bool
+	TransparentX    int
+	TransparentY    int
+	// Weight scales contribution of the texture to palette calculation.
+	Weight float64
+	// MaskFilename is grayscale image marking important pixels, empty if none.
+	MaskFilename string
+	Line         int
+}
+
+const DefaultAlphaThreshold = 128
+
+type PaletteMode int
+
+const (
+	// PaletteFull uses loaded palette without calculation.
+	PaletteFull PaletteMode = iota
+	// PalettePartial fixes loaded colors and calculates the remaining slots.
+	PalettePartial
+)
+
+type FixedColor struct {
+	Index int
+	Color palette.IntColor
+	Line  int
+}
+
+type File struct {
+	Filename string
+	Colors   int
+	Offset   int
+	Indexer  dither.ImageIndexer
+	// ColorSpace is used to measure color distance both for palette
+	// calculation and indexing.
+	ColorSpace palette.ColorSpace
+	Quantizer  quantize.Method
+	// Workers is number of goroutines for palette calculation and indexing,
+	// all CPUs if zero.
+	Workers int
+	// Quantize controls palette calculation.
+	Quantize quantize.Settings
+	// NormalizeArea makes every texture contribute to palette calculation
+	// as if it had the same number of pixels.
+	NormalizeArea bool
+	// MaskDither lowers dithering of pixels marked by texture masks, from 0
+	// (masks do not affect dithering) to 1 (white pixels are not dithered).
+	MaskDither float64
+	// Seed makes palette calculation reproducible, random if nil.
+	Seed     *int64
+	Textures []TextureEntry
+	Warnings Diagnostics
+
+	// AlphaThreshold enables transparency from image alpha when above zero:
+	// pixels with lower alpha become transparent.
+	AlphaThreshold int
+	// TransparentIndex is the palette index (without offset) reserved for
+	// transparent pixels, or -1 if none is reserved.
+	TransparentIndex int
+	// KeyColor makes all pixels of this color transparent.
+	KeyColor *palette.IntColor
+	// FixedColors are pinned to palette indices (without offset).
+	FixedColors []FixedColor
+	// PaletteFile is a predefined palette used instead of calculated one.
+	PaletteFile string
+	PaletteMode PaletteMode
+}
+
+func (file *File) HasReservedIndex() bool {
+	return file.TransparentIndex >= 0
+}
+
+// TransparentColor is the color stored in the reserved palette slot.
+func (file *File) TransparentColor() palette.IntColor {
+	if file.KeyColor != nil {
+		return *file.KeyColor
+	}
+	return palette.IntColor{}
+}
+
+type field struct {
+	Text   string
+	Column int
+}
+
+type parser struct {
+	filename string
+	folder   string
+	line     int
+	result   *File
+	errors   Diagnostics
+	names    map[string]int
+
+	colorsLine      int
+	offsetLine      int
+	transparentLine int
+}
+
+func (p *parser) report(severity Severity, f field, format string, args ...any) {
+	diag := Diagnostic{
+		Severity: severity,
+		File:     p.filename,
+		Line:     p.line,
+		Column:   f.Column,
+		Text:     f.Text,
+		Msg:      fmt.Sprintf(format, args...),
+	}
+	if severity == SeverityWarning {
+		p.result.Warnings = append(p.result.Warnings, diag)
+	} else {
+		p.errors = append(p.errors, diag)
+	}
+}
+
+func (p *parser) errorf(f field, format string, args ...any) {
+	p.report(SeverityError, f, format, args...)
+}
+
+func (p *parser) warnf(f field, format string, args ...any) {
+	p.report(SeverityWarning, f, format, args...)
+}
+
+// splitFields splits line into shell-like fields keeping their column numbers.
+func splitFields(text string, column int) ([]field, *field) {
+	result := make([]field, 0)
+	i := 0
+	for i < len(text) {
+		if text[i] == ' ' || text[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		var quote byte
+		for i < len(text) {
+			c := text[i]
+			if quote != 0 {
+				if c == '\\' && quote == '"' {
+					i += 2
+					continue
+				}
+				if c == quote {
+					quote = 0
+				}
+				i++
+				continue
+			}
+			if c == ' ' || c == '\t' {
+				break
+			}
+			if c == '\\' {
+				i += 2
+				continue
+			}
+			if c == '"' || c == '\'' {
+				quote = c
+			}
+			i++
+		}
+		if i > len(text) {
+			i = len(text)
+		}
+		raw := text[start:i]
+		if quote != 0 {
+			return nil, &field{raw, start + column}
+		}
+		parts, err := shlex.Split(raw)
+		if err != nil {
+			return nil, &field{raw, start + column}
+		}
+		if len(parts) == 0 {
+			// comment
+			break
+		}
+		result = append(result, field{strings.Join(parts, ""), start + column})
+	}
+	return result, nil
+}
+
+// path makes path relative to project folder.
+func (p *parser) path(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.Join(p.folder, path)
+	}
+	return path
+}
+
+func (p *parser) intArg(fields []field, index int, min int, max int) (int, bool) {
+	if index >= len(fields) {
+		p.errorf(fields[0], "Not enough arguments for command '%s'", fields[0].Text)
+		return 0, false
+	}
+	value, err := strconv.Atoi(fields[index].Text)
+	if err != nil || value < min || value > max {
+		p.errorf(fields[index], "Wrong argument for command '%s' (must be %d..%d)", fields[0].Text, min, max)
+		return 0, false
+	}
+	return value, true
+}
+
+func (p *parser) floatArg(fields []field, index int) (float64, bool) {
+	if index >= len(fields) {
+		p.errorf(fields[0], "Not enough arguments for command '%s'", fields[0].Text)
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(fields[index].Text, 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		p.errorf(fields[index], "Wrong argument for command '%s' (must be non-negative number)", fields[0].Text)
+		return 0, false
+	}
+	return value, true
+}
+
+// ParseDuration accepts Go durations like "1m30s" or plain number of seconds.
+func ParseDuration(text string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(text, 64); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("negative duration")
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err == nil && duration < 0 {
+		return 0, fmt.Errorf("negative duration")
+	}
+	return duration, err
+}
+
+func (p *parser) colorArgs(fields []field, index int) (palette.IntColor, bool) {
+	var rgb [3]int
+	for i := range rgb {
+		var ok bool
+		if rgb[i], ok = p.intArg(fields, index+i, 0, 255); !ok {
+			return palette.IntColor{}, false
+		}
+	}
+	return palette.IntColor{R: rgb[0], G: rgb[1], B: rgb[2]}, true
+}
+
+func (p *parser) parseCommand(fields []field) {
+	switch fields[0].Text {
+	case "colors":
+		if colors, ok := p.intArg(fields, 1, 1, 256); ok {
+			p.result.Colors = colors
+			p.colorsLine = p.line
+		}
+	case "offset":
+		if offset, ok := p.intArg(fields, 1, 0, 255); ok {
+			p.result.Offset = offset
+			p.offsetLine = p.line
+		}
+	case "indexer":
+		if len(fields) < 2 {
+			p.errorf(fields[0], "Not enough arguments for command 'indexer'")
+			return
+		}
+		indexer, err := dither.GetIndexer(fields[1].Text)
+		if err != nil {
+			p.errorf(fields[1], "%v", err)
+			return
+		}
+		p.result.Indexer = indexer
+	case "colorspace":
+		if len(fields) < 2 {
+			p.errorf(fields[0], "Not enough arguments for command 'colorspace'")
+			return
+		}
+		space, err := palette.GetColorSpace(fields[1].Text)
+		if err != nil {
+			p.errorf(fields[1], "%v", err)
+			return
+		}
+		p.result.ColorSpace = space
+	case "quantizer":
+		if len(fields) < 2 {
+			p.errorf(fields[0], "Not enough arguments for command 'quantizer'")
+			return
+		}
+		method, err := quantize.GetMethod(fields[1].Text)
+		if err != nil {
+			p.errorf(fields[1], "%v", err)
+			return
+		}
+		p.result.Quantizer = method
+	case "colorbits":
+		if bits, ok := p.intArg(fields, 1, 1, 8); ok {
+			p.result.Quantize.HistogramBits = bits
+		}
+	case "steps":
+		if steps, ok := p.intArg(fields, 1, 1, math.MaxInt32); ok {
+			p.result.Quantize.Steps = steps
+		}
+	case "attempts":
+		if attempts, ok := p.intArg(fields, 1, 1, math.MaxInt32); ok {
+			p.result.Quantize.Attempts = attempts
+		}
+	case "tolerance":
+		if movement, ok := p.floatArg(fields, 1); ok {
+			p.result.Quantize.Tolerance = movement
+		}
+		if len(fields) > 2 {
+			if improvement, ok := p.floatArg(fields, 2); ok {
+				p.result.Quantize.Improvement = improvement
+			}
+		}
+	case "timelimit":
+		if len(fields) < 2 {
+			p.errorf(fields[0], "Not enough arguments for command 'timelimit'")
+			return
+		}
+		limit, err := ParseDuration(fields[1].Text)
+		if err != nil {
+			p.errorf(fields[1], "Wrong argument for command 'timelimit' (must be duration like 90s or 5m)")
+			return
+		}
+		p.result.Quantize.TimeLimit = limit
+	case "normalize":
+		if len(fields) < 2 {
+			p.errorf(fields[0], "Not enough arguments for command 'normalize'")
+			return
+		}
+		switch fields[1].Text {
+		case "area":
+			p.result.NormalizeArea = true
+		case "none":
+			p.result.NormalizeArea = false
+		default:
+			p.errorf(fields[1], "Wrong argument for command 'normalize' (must be area or none)")
+		}
+	case "maskdither":
+		if amount, ok := p.floatArg(fields, 1); ok {
+			if amount > 1 {
+				p.errorf(fields[1], "Wrong argument for command 'maskdither' (must be 0..1)")
+				return
+			}
+			p.result.MaskDither = amount
+		}
+	case "workers":
+		if workers, ok := p.intArg(fields, 1, 0, 1024); ok {
+			p.result.Workers = workers
+		}
+	case "seed":
+		if len(fields) < 2 {
+			p.errorf(fields[0], "Not enough arguments for command 'seed'")
+			return
+		}
+		seed, err := strconv.ParseInt(fields[1].Text, 10, 64)
+		if err != nil {
+			p.errorf(fields[1], "Wrong argument for command 'seed' (must be integer)")
+			return
+		}
+		p.result.Seed = &seed
+	case "alpha":
+		p.result.AlphaThreshold = DefaultAlphaThreshold
+		if len(fields) > 1 {
+			if threshold, ok := p.intArg(fields, 1, 1, 256); ok {
+				p.result.AlphaThreshold = threshold
+			}
+		}
+	case "transparent":
+		index, ok := p.intArg(fields, 1, 0, 255)
+		if !ok {
+			return
+		}
+		p.result.TransparentIndex = index
+		p.transparentLine = p.line
+		if len(fields) == 2 {
+			return
+		}
+		if len(fields) != 5 {
+			p.errorf(fields[0], "Key color for command 'transparent' needs 3 components")
+			return
+		}
+		if key, ok := p.colorArgs(fields, 2); ok {
+			p.result.KeyColor = &key
+		}
+	case "palette":
+		if len(fields) < 2 {
+			p.errorf(fields[0], "Not enough arguments for command 'palette'")
+			return
+		}
+		p.result.PaletteFile = p.path(fields[1].Text)
+		p.result.PaletteMode = PaletteFull
+		if len(fields) > 2 {
+			switch fields[2].Text {
+			case "full":
+			case "partial":
+				p.result.PaletteMode = PalettePartial
+			default:
+				p.errorf(fields[2], "Wrong mode for command 'palette' (must be full or partial)")
+			}
+		}
+	case "fixedcolor":
+		index, ok := p.intArg(fields, 1, 0, 255)
+		if !ok {
+			return
+		}
+		color, ok := p.colorArgs(fields, 2)
+		if !ok {
+			return
+		}
+		for _, fixed := range p.result.FixedColors {
+			if fixed.Index == index {
+				p.errorf(fields[1], "Index %d is already fixed on line %d", index, fixed.Line)
+				return
+			}
+		}
+		p.result.FixedColors = append(p.result.FixedColors, FixedColor{index, color, p.line})
+	default:
+		p.warnf(fields[0], "Unknown command '%s' ignored", fields[0].Text)
+	}
+}
+
+// parseAttribute parses texture attribute like weight=2 or mask=face.png.
+func (p *parser) parseAttribute(entry *TextureEntry, f field) {
+	key, value, _ := strings.Cut(f.Text, "=")
+	switch key {
+	case "weight":
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			p.errorf(f, "Wrong texture weight (must be non-negative number)")
+			return
+		}
+		entry.Weight = weight
+	case "mask":
+		if value == "" {
+			p.errorf(f, "Texture mask needs a file name")
+			return
+		}
+		entry.MaskFilename = p.path(value)
+	default:
+		p.errorf(f, "Unknown texture attribute '%s'", key)
+	}
+}
+
+func (p *parser) parseTexture(fields []field) {
+	// attributes follow name and file
+	attributes := make([]field, 0)
+	positional := make([]field, 0, len(fields))
+	for i, f := range fields {
+		if i >= 2 && strings.Contains(f.Text, "=") {
+			attributes = append(attributes, f)
+		} else {
+			positional = append(positional, f)
+		}
+	}
+	fields = positional
+	if len(fields) != 2 && len(fields) != 4 {
+		p.errorf(fields[0], "Wrong number of arguments for texture (must be 2 or 4, got %d)", len(fields))
+		return
+	}
+
+	name := fields[0].Text
+	if len(name) > MaxNameLength {
+		name = name[:MaxNameLength]
+		p.warnf(fields[0], "Name will be cropped to \"%s\"", name)
+	}
+	if line, ok := p.names[name]; ok {
+		p.errorf(fields[0], "Name \"%s\" is not unique (first used on line %d)", name, line)
+	} else {
+		p.names[name] = p.line
+	}
+
+	entry := TextureEntry{
+		Name:     name,
+		Filename: p.path(fields[1].Text),
+		Weight:   1,
+		Line:     p.line,
+	}
+	if len(fields) == 4 {
+		var err error
+		entry.HasTransparency = true
+		entry.TransparentX, err = strconv.Atoi(fields[2].Text)
+		if err != nil || entry.TransparentX < 0 {
+			p.errorf(fields[2], "Wrong argument for X coordinate")
+		}
+		entry.TransparentY, err = strconv.Atoi(fields[3].Text)
+		if err != nil || entry.TransparentY < 0 {
+			p.errorf(fields[3], "Wrong argument for Y coordinate")
+		}
+	}
+	for _, f := range attributes {
+		p.parseAttribute(&entry, f)
+	}
+	p.result.Textures = append(p.result.Textures, entry)
+}
+
+func Open(filename string) (File, error) {
+	result := File{
+		Filename: filename,
+		Colors:   256,
+		Offset:   0,
+		Indexer:  dither.IndexerPosterize,
+		Quantize: quantize.DefaultSettings(),
+		Textures: make([]TextureEntry, 0),
+
+		TransparentIndex: -1,
+	}
+	p := &parser{
+		filename: filename,
+		result:   &result,
+		names:    make(map[string]int),
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		p.errorf(field{}, "%v", err)
+		return result, p.errors
+	}
+	defer file.Close()
+
+	p.folder, err = filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		p.errorf(field{}, "%v", err)
+		return result, p.errors
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		p.line++
+		text := scanner.Text()
+		command := false
+		column := 1
+		if (len(text)) == 0 {
+			continue
+		}
+		if text[0] == '#' {
+			command = true
+			text = text[1:]
+			column++
+		}
+		fields, bad := splitFields(text, column)
+		if bad != nil {
+			p.errorf(*bad, "Unterminated quote or escape")
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if command {
+			p.parseCommand(fields)
+		} else {
+			p.parseTexture(fields)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		p.errorf(field{}, "%v", err)
+	}
+
+	if result.Colors+result.Offset > 256 {
+		p.line = p.colorsLine
+		if p.offsetLine > p.line {
+			p.line = p.offsetLine
+		}
+		p.errorf(field{}, "Wrong number of colors (%d+%d>256)", result.Colors, result.Offset)
+	}
+	if result.AlphaThreshold > 0 && !result.HasReservedIndex() {
+		result.TransparentIndex = 0
+	}
+	if result.HasReservedIndex() && result.Colors < 2 {
+		p.line = p.colorsLine
+		p.errorf(field{}, "At least 2 colors are needed when transparent index is reserved")
+	}
+	if result.TransparentIndex >= result.Colors {
+		p.line = p.transparentLine
+		p.errorf(field{}, "Transparent index %d is outside of palette (%d colors)", result.TransparentIndex, result.Colors)
+	}
+	for _, fixed := range result.FixedColors {
+		p.line = fixed.Line
+		if fixed.Index >= result.Colors {
+			p.errorf(field{}, "Fixed color index %d is outside of palette (%d colors)", fixed.Index, result.Colors)
+		}
+		if fixed.Index == result.TransparentIndex {
+			p.errorf(field{}, "Fixed color index %d is reserved for transparency", fixed.Index)
+		}
+	}
+	if len(p.errors) > 0 {
+		return result, p.errors
+	}
+	return result, nil
+}
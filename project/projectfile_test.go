@@ -0,0 +1,163 @@
+package project
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type diag struct {
+	Severity Severity
+	Line     int
+	Column   int
+	Msg      string
+}
+
+func openText(t *testing.T, text string) (File, Diagnostics, error) {
+	filename := filepath.Join(t.TempDir(), "project.txt")
+	if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Open(filename)
+	var errs Diagnostics
+	if err != nil && !errors.As(err, &errs) {
+		t.Fatalf("error is not Diagnostics: %v", err)
+	}
+	return result, errs, err
+}
+
+func TestOpenDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []diag
+	}{
+		{"valid", "#colors 16\n#offset 8\n\n## comment\nwall wall.png 0 0 weight=2 # trailing comment\n", nil},
+		{"missing argument", "#colors\n", []diag{
+			{SeverityError, 1, 2, "Not enough arguments for command 'colors'"},
+		}},
+		{"wrong argument", "#colors 300\n", []diag{
+			{SeverityError, 1, 9, "Wrong argument for command 'colors' (must be 1..256)"},
+		}},
+		{"second argument", "#tolerance 0.5  x\n", []diag{
+			{SeverityError, 1, 17, "Wrong argument for command 'tolerance' (must be non-negative number)"},
+		}},
+		{"color component", "#fixedcolor 1 10 20 300\n", []diag{
+			{SeverityError, 1, 21, "Wrong argument for command 'fixedcolor' (must be 0..255)"},
+		}},
+		{"key color", "#colors 16\n#transparent 0 1 2\n", []diag{
+			{SeverityError, 2, 2, "Key color for command 'transparent' needs 3 components"},
+		}},
+		{"unknown command", "#frobnicate 1\n#colors 8\n", []diag{
+			{SeverityWarning, 1, 2, "Unknown command 'frobnicate' ignored"},
+		}},
+		{"unterminated quote", "wall \"wall.png\n", []diag{
+			{SeverityError, 1, 6, "Unterminated quote or escape"},
+		}},
+		{"unterminated single quote", "  wall 'wall.png\n", []diag{
+			{SeverityError, 1, 8, "Unterminated quote or escape"},
+		}},
+		{"unterminated escape", "wall wall.png\\\n", []diag{
+			{SeverityError, 1, 6, "Unterminated quote or escape"},
+		}},
+		{"quoted command", "#palette \"base.pal\" whole\n", []diag{
+			{SeverityError, 1, 21, "Wrong mode for command 'palette' (must be full or partial)"},
+		}},
+		{"texture arguments", "wall wall.png 1\n", []diag{
+			{SeverityError, 1, 1, "Wrong number of arguments for texture (must be 2 or 4, got 3)"},
+		}},
+		{"texture coordinates", "wall wall.png -1 y\n", []diag{
+			{SeverityError, 1, 15, "Wrong argument for X coordinate"},
+			{SeverityError, 1, 18, "Wrong argument for Y coordinate"},
+		}},
+		{"texture attribute", "wall wall.png weight=-1 size=2\n", []diag{
+			{SeverityError, 1, 15, "Wrong texture weight (must be non-negative number)"},
+			{SeverityError, 1, 25, "Unknown texture attribute 'size'"},
+		}},
+		{"duplicate name", "wall a.png\nfloor b.png\n wall c.png\n", []diag{
+			{SeverityError, 3, 2, "Name \"wall\" is not unique (first used on line 1)"},
+		}},
+		{"truncated name", "abcdefghijklmnopq a.png\nabcdefghijklmnopqr b.png\n", []diag{
+			{SeverityWarning, 1, 1, "Name will be cropped to \"abcdefghijklmnop\""},
+			{SeverityWarning, 2, 1, "Name will be cropped to \"abcdefghijklmnop\""},
+			{SeverityError, 2, 1, "Name \"abcdefghijklmnop\" is not unique (first used on line 1)"},
+		}},
+		{"duplicate fixed color", "#fixedcolor 3 0 0 0\n#fixedcolor 3 1 1 1\n", []diag{
+			{SeverityError, 2, 13, "Index 3 is already fixed on line 1"},
+		}},
+		{"palette size", "#colors 200\n#offset 100\n", []diag{
+			{SeverityError, 2, 0, "Wrong number of colors (200+100>256)"},
+		}},
+		{"fixed outside palette", "#fixedcolor 20 0 0 0\n#colors 16\n", []diag{
+			{SeverityError, 1, 0, "Fixed color index 20 is outside of palette (16 colors)"},
+		}},
+		{"many errors", "#colors x\n#dither fs\nwall \"a.png\nwall a.png\nwall b.png 1 2 3\nwall c.png\n#transparent 300\n", []diag{
+			{SeverityError, 1, 9, "Wrong argument for command 'colors' (must be 1..256)"},
+			{SeverityWarning, 2, 2, "Unknown command 'dither' ignored"},
+			{SeverityError, 3, 6, "Unterminated quote or escape"},
+			{SeverityError, 5, 1, "Wrong number of arguments for texture (must be 2 or 4, got 5)"},
+			{SeverityError, 6, 1, "Name \"wall\" is not unique (first used on line 4)"},
+			{SeverityError, 7, 14, "Wrong argument for command 'transparent' (must be 0..255)"},
+		}},
+		{"many final errors", "#colors 1\n#transparent 1\n", []diag{
+			{SeverityError, 1, 0, "At least 2 colors are needed when transparent index is reserved"},
+			{SeverityError, 2, 0, "Transparent index 1 is outside of palette (1 colors)"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, errs, err := openText(t, test.text)
+			got := make([]diag, 0)
+			// warnings and errors are compared together, ordered by line
+			all := append(Diagnostics{}, result.Warnings...)
+			all = append(all, errs...)
+			for _, d := range all {
+				got = append(got, diag{d.Severity, d.Line, d.Column, d.Msg})
+			}
+			sortDiags(got)
+			want := append([]diag{}, test.want...)
+			sortDiags(want)
+			if len(got) != len(want) {
+				t.Fatalf("got %d diagnostics, want %d:\n%v", len(got), len(want), all)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("diagnostic %d is %+v, want %+v", i, got[i], want[i])
+				}
+			}
+			hasErrors := false
+			for _, d := range want {
+				hasErrors = hasErrors || d.Severity == SeverityError
+			}
+			if hasErrors != (err != nil) {
+				t.Errorf("error is %v, want errors: %v", err, hasErrors)
+			}
+		})
+	}
+}
+
+// sortDiags orders diagnostics by line keeping order inside the line.
+func sortDiags(diags []diag) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Line < diags[j].Line
+	})
+}
+
+func TestDiagnosticError(t *testing.T) {
+	tests := []struct {
+		diag Diagnostic
+		want string
+	}{
+		{Diagnostic{File: "a.txt", Msg: "no file"}, "a.txt: no file"},
+		{Diagnostic{File: "a.txt", Line: 3, Msg: "bad"}, "a.txt:3: bad"},
+		{Diagnostic{File: "a.txt", Line: 3, Column: 7, Text: "x", Msg: "bad"}, "a.txt:3:7: bad (\"x\")"},
+		{Diagnostic{Severity: SeverityWarning, File: "a.txt", Line: 1, Column: 2, Msg: "odd"}, "a.txt:1:2: warning: odd"},
+	}
+	for _, test := range tests {
+		if got := test.diag.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package quantize
+
+import (
+	"context"
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+var boxMethods = []Method{MethodMedianCut, MethodOctree, MethodWu}
+
+func runBox(t *testing.T, method Method, colors int, bits int, space palette.ColorSpace, fixed []FixedColor, images [][]palette.IntColor) *base {
+	q := New(method, colors, Settings{HistogramBits: bits, Reporter: silentReporter{}})
+	q.SetFixed(fixed)
+	q.SetColorSpace(space)
+	if err := q.Input(images); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.RunContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	switch q := q.(type) {
+	case *MedianCut:
+		return &q.base
+	case *Octree:
+		return &q.base
+	case *Wu:
+		return &q.base
+	}
+	t.Fatalf("%s is not box quantizer", method)
+	return nil
+}
+
+func TestBoxExactColors(t *testing.T) {
+	// colors lie in different cells of Wu histogram
+	input := make(palette.Palette, 0)
+	for _, c := range [][3]int{{0, 0, 0}, {255, 255, 255}, {10, 100, 200}, {200, 100, 10}, {77, 77, 77}, {255, 0, 0}, {0, 130, 0}, {40, 40, 250}} {
+		input = append(input, palette.IntColor{R: c[0], G: c[1], B: c[2]})
+	}
+	img := make([]palette.IntColor, 0)
+	for i, c := range input {
+		for j := 0; j <= i*3; j++ {
+			img = append(img, c)
+		}
+	}
+	fixed := []FixedColor{{Index: 2, Color: palette.IntColor{R: 255, B: 255}}}
+	for _, method := range boxMethods {
+		for _, colors := range []int{len(input) + 1, 256} {
+			for _, space := range []palette.ColorSpace{palette.ColorSpaceRGB, palette.ColorSpaceLab, palette.ColorSpaceOKLab} {
+				q := runBox(t, method, colors, 8, space, fixed, [][]palette.IntColor{img})
+				pal := q.result
+				if len(pal) != len(input)+1 {
+					t.Errorf("%s, %s, %d colors: got %d colors, want %d", method, space, colors, len(pal), len(input)+1)
+					continue
+				}
+				if pal[2] != fixed[0].Color {
+					t.Errorf("%s, %s, %d colors: color 2 is %v, want fixed %v", method, space, colors, pal[2], fixed[0].Color)
+				}
+				for _, c := range input {
+					found := false
+					for i, p := range pal {
+						found = found || (i != 2 && p == c)
+					}
+					if !found {
+						t.Errorf("%s, %s, %d colors: %v is missing in %v", method, space, colors, c, pal)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBoxFreeColors(t *testing.T) {
+	images := [][]palette.IntColor{loadTestImage(t, "gatox01.png")}
+	fixed := []FixedColor{
+		{Index: 0, Color: palette.IntColor{}},
+		{Index: 5, Color: palette.IntColor{R: 255, G: 255}},
+		{Index: 6, Color: palette.IntColor{R: 255, B: 255}},
+	}
+	for _, method := range boxMethods {
+		for _, colors := range []int{1, 3, 4, 7, 64} {
+			for _, fixedCount := range []int{0, 1, 3} {
+				if fixedCount > 0 && fixed[fixedCount-1].Index >= colors {
+					continue
+				}
+				q := runBox(t, method, colors, 5, palette.ColorSpaceRGB, fixed[:fixedCount], images)
+				if len(q.free) > q.freeColors() || len(q.free) == 0 && q.freeColors() > 0 {
+					t.Errorf("%s, %d colors, %d fixed: calculated %d colors for %d free slots", method, colors, fixedCount, len(q.free), q.freeColors())
+				}
+				if len(q.result) != q.colors {
+					t.Errorf("%s, %d colors, %d fixed: got %d colors, want %d", method, colors, fixedCount, len(q.result), q.colors)
+				}
+				for _, f := range fixed[:fixedCount] {
+					if q.result[f.Index] != f.Color {
+						t.Errorf("%s, %d colors, %d fixed: color %d is %v, want fixed %v", method, colors, fixedCount, f.Index, q.result[f.Index], f.Color)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package quantize
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+func randomPalCalc(t *testing.T) *PalCalc {
+	rng := rand.New(rand.NewSource(1))
+	img := make([]palette.IntColor, 300000)
+	for i := range img {
+		img[i] = palette.IntColor{R: rng.Intn(256), G: rng.Intn(256), B: rng.Intn(256)}
+	}
+	km := NewPalCalc(256, 1000, 10)
+	km.SetWorkers(1)
+	km.SetSeed(1)
+	km.SetReporter(silentReporter{})
+	if err := km.Input([][]palette.IntColor{img}); err != nil {
+		t.Fatal(err)
+	}
+	return km
+}
+
+func TestPalCalcCancel(t *testing.T) {
+	km := randomPalCalc(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := km.RunContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if len(km.GetPalette()) != 256 {
+		t.Errorf("got %d colors after cancel, want 256", len(km.GetPalette()))
+	}
+
+	for _, timeout := range []time.Duration{50 * time.Millisecond, 700 * time.Millisecond} {
+		km := randomPalCalc(t)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := km.RunContext(ctx)
+		elapsed := time.Since(start)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("timeout %s: got error %v, want context.DeadlineExceeded", timeout, err)
+		}
+		if elapsed > timeout+300*time.Millisecond {
+			t.Errorf("timeout %s: returned after %s", timeout, elapsed)
+		}
+		if len(km.GetPalette()) != 256 {
+			t.Errorf("timeout %s: got %d colors, want 256", timeout, len(km.GetPalette()))
+		}
+	}
+}
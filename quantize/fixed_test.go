@@ -0,0 +1,72 @@
+package quantize
+
+import (
+	"context"
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+func TestFixedColors(t *testing.T) {
+	few := make([]palette.IntColor, 0)
+	for i := 0; i < 300; i++ {
+		few = append(few, palette.IntColor{R: 10}, palette.IntColor{G: 200, B: 40}, palette.IntColor{R: 90, G: 90, B: 90})
+	}
+	tests := []struct {
+		name   string
+		image  []palette.IntColor
+		colors int
+		fixed  []FixedColor
+		size   int
+	}{
+		{"many colors", loadTestImage(t, "gatox01.png"), 16, []FixedColor{
+			{Index: 0, Color: palette.IntColor{R: 255, B: 255}},
+			{Index: 7, Color: palette.IntColor{R: 1, G: 2, B: 3}},
+			{Index: 15, Color: palette.IntColor{R: 255, G: 255, B: 255}},
+		}, 16},
+		// 3 input colors reduce palette to 5 colors, fixed index 12 stays
+		{"few colors", few, 16, []FixedColor{
+			{Index: 12, Color: palette.IntColor{R: 255, B: 255}},
+			{Index: 3, Color: palette.IntColor{R: 10}},
+		}, 13},
+	}
+	methods := []Method{MethodKMeans, MethodMedianCut, MethodOctree, MethodWu, MethodWuKMeans}
+	for _, test := range tests {
+		for _, method := range methods {
+			for _, space := range []palette.ColorSpace{palette.ColorSpaceRGB, palette.ColorSpaceLab} {
+				q := New(method, test.colors, Settings{HistogramBits: 5, Steps: 20, Attempts: 2, Workers: 1, Reporter: silentReporter{}})
+				q.SetFixed(test.fixed)
+				q.SetColorSpace(space)
+				q.SetSeed(1)
+				if err := q.Input([][]palette.IntColor{test.image}); err != nil {
+					t.Fatal(err)
+				}
+				if err := q.RunContext(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				pal := q.GetPalette()
+				if len(pal) != test.size {
+					t.Errorf("%s, %s, %s: %d colors, want %d", test.name, method, space, len(pal), test.size)
+					continue
+				}
+				for _, fixed := range test.fixed {
+					if pal[fixed.Index] != fixed.Color {
+						t.Errorf("%s, %s, %s: color %d is %v, want fixed %v", test.name, method, space, fixed.Index, pal[fixed.Index], fixed.Color)
+					}
+				}
+				km, ok := q.(*PalCalc)
+				if wukm, isWu := q.(*WuKMeans); isWu {
+					km, ok = wukm.PalCalc, true
+				}
+				if !ok {
+					continue
+				}
+				for i, fixed := range test.fixed {
+					if want := space.Convert(fixed.Color.ToFloatColor()); km.centroids[i] != want {
+						t.Errorf("%s, %s, %s: fixed centroid %d moved to %v, want %v", test.name, method, space, i, km.centroids[i], want)
+					}
+				}
+			}
+		}
+	}
+}
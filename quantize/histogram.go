@@ -0,0 +1,157 @@
+package quantize
+
+import (
+	"math"
+	"sort"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+func packColor(color palette.IntColor) uint32 {
+	return uint32(color.R)<<16 | uint32(color.G)<<8 | uint32(color.B)
+}
+
+func unpackColor(key uint32) palette.IntColor {
+	return palette.IntColor{R: int(key >> 16 & 0xFF), G: int(key >> 8 & 0xFF), B: int(key & 0xFF)}
+}
+
+// weightScale is fixed point precision of image weights: the smallest non-zero
+// weight is counted as weightScale per pixel.
+const weightScale = 256
+
+// maxWeightUnits limits counts per pixel for extreme weight ratios, so that
+// moments of large images still fit into 64 bits.
+const maxWeightUnits = 1 << 20
+
+// weightUnits converts image weights into counts added per pixel. Equal weights
+// (or no weights) count every pixel once unless pixels are masked. Weights are
+// scaled relative to the smallest non-zero one, so no positive weight drops
+// its image.
+func weightUnits(weights []float64, images int, masked bool) []uint64 {
+	units := make([]uint64, images)
+	if !masked && len(weights) == 0 {
+		for i := range units {
+			units[i] = 1
+		}
+		return units
+	}
+	// images without weight count as 1
+	all := make([]float64, images)
+	smallest := 0.0
+	for i := range all {
+		all[i] = 1
+		if i < len(weights) {
+			all[i] = weights[i]
+		}
+		if all[i] > 0 && (smallest == 0 || all[i] < smallest) {
+			smallest = all[i]
+		}
+	}
+	equal := true
+	for _, weight := range all {
+		if weight != smallest {
+			equal = false
+		}
+	}
+	for i, weight := range all {
+		switch {
+		case weight == 0:
+			units[i] = 0
+		case equal && !masked:
+			units[i] = 1
+		default:
+			units[i] = uint64(math.Round(math.Min(weight/smallest*weightScale, maxWeightUnits)))
+		}
+	}
+	return units
+}
+
+type colorBin struct {
+	r, g, b uint64
+	count   uint64
+}
+
+// countColors returns every distinct color of images with number of its pixels
+// scaled by image weights and pixel masks, ordered by RGB. With bits below 8
+// colors are grouped into bins with given precision per channel as they are
+// counted, each bin is represented by mean color of its pixels.
+func countColors(images [][]palette.IntColor, weights []float64, masks [][]uint8, bits int) ([]palette.IntColor, []uint64) {
+	masked := false
+	for _, mask := range masks {
+		if mask != nil {
+			masked = true
+		}
+	}
+	units := weightUnits(weights, len(images), masked)
+	// each pixel is passed to add with its count
+	eachPixel := func(add func(color palette.IntColor, count uint64)) {
+		for i, img := range images {
+			if units[i] == 0 {
+				continue
+			}
+			var mask []uint8
+			if i < len(masks) {
+				mask = masks[i]
+			}
+			for p, data := range img {
+				count := units[i]
+				if mask != nil {
+					count = (count*uint64(mask[p]) + 127) / 255
+					if count == 0 {
+						continue
+					}
+				}
+				add(data, count)
+			}
+		}
+	}
+
+	if bits <= 0 || bits >= 8 {
+		histogram := make(map[uint32]uint64)
+		eachPixel(func(color palette.IntColor, count uint64) {
+			histogram[packColor(color)] += count
+		})
+		keys := sortedKeys(histogram)
+		colors := make([]palette.IntColor, len(keys))
+		counts := make([]uint64, len(keys))
+		for i, key := range keys {
+			colors[i] = unpackColor(key)
+			counts[i] = histogram[key]
+		}
+		return colors, counts
+	}
+
+	shift := 8 - bits
+	bins := make(map[uint32]colorBin)
+	eachPixel(func(color palette.IntColor, count uint64) {
+		key := packColor(palette.IntColor{R: color.R >> shift, G: color.G >> shift, B: color.B >> shift})
+		bin := bins[key]
+		bin.r += uint64(color.R) * count
+		bin.g += uint64(color.G) * count
+		bin.b += uint64(color.B) * count
+		bin.count += count
+		bins[key] = bin
+	})
+	keys := sortedKeys(bins)
+	colors := make([]palette.IntColor, len(keys))
+	counts := make([]uint64, len(keys))
+	for i, key := range keys {
+		bin := bins[key]
+		half := bin.count / 2
+		colors[i] = palette.IntColor{
+			R: int((bin.r + half) / bin.count),
+			G: int((bin.g + half) / bin.count),
+			B: int((bin.b + half) / bin.count)}
+		counts[i] = bin.count
+	}
+	return colors, counts
+}
+
+func sortedKeys[V any](m map[uint32]V) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
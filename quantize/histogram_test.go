@@ -0,0 +1,114 @@
+package quantize
+
+import (
+	"fmt"
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+func TestCountColorsBins(t *testing.T) {
+	red := func(r int) palette.IntColor { return palette.IntColor{R: r} }
+	images := [][]palette.IntColor{
+		{red(0), red(3), red(3), red(8), red(255)},
+		{red(6)},
+	}
+	tests := []struct {
+		bits    int
+		weights []float64
+		colors  []palette.IntColor
+		counts  []uint64
+	}{
+		{8, nil, []palette.IntColor{red(0), red(3), red(6), red(8), red(255)}, []uint64{1, 2, 1, 1, 1}},
+		// 0, 3, 3 and 6 share a bin, mean (0+3+3+6)/4 = 3
+		{5, nil, []palette.IntColor{red(3), red(8), red(255)}, []uint64{4, 1, 1}},
+		// mean (0+3+3+6*3)/6 = 4
+		{5, []float64{1, 3}, []palette.IntColor{red(4), red(8), red(255)}, []uint64{6 * weightScale, weightScale, weightScale}},
+		{1, nil, []palette.IntColor{red(4), red(255)}, []uint64{5, 1}},
+	}
+	for _, test := range tests {
+		colors, counts := countColors(images, test.weights, nil, test.bits)
+		if fmt.Sprint(colors) != fmt.Sprint(test.colors) || fmt.Sprint(counts) != fmt.Sprint(test.counts) {
+			t.Errorf("bits %d, weights %v: got %v %v, want %v %v", test.bits, test.weights, colors, counts, test.colors, test.counts)
+		}
+	}
+}
+
+// countColorsCube is the dense histogram used before sparse one, kept as
+// benchmark baseline.
+func countColorsCube(images [][]palette.IntColor) ([]palette.IntColor, []uint64) {
+	cube := new([256][256][256]uint64)
+	for _, img := range images {
+		for _, data := range img {
+			cube[data.R][data.G][data.B]++
+		}
+	}
+	colors := make([]palette.IntColor, 0)
+	counts := make([]uint64, 0)
+	for r := 0; r < 256; r++ {
+		for g := 0; g < 256; g++ {
+			for b := 0; b < 256; b++ {
+				if cube[r][g][b] > 0 {
+					colors = append(colors, palette.IntColor{R: r, G: g, B: b})
+					counts = append(counts, cube[r][g][b])
+				}
+			}
+		}
+	}
+	return colors, counts
+}
+
+func TestCountColorsCube(t *testing.T) {
+	images := [][]palette.IntColor{loadTestImage(t, "gatox01a.png")}
+	wantColors, wantCounts := countColorsCube(images)
+	colors, counts := countColors(images, nil, nil, 8)
+	if fmt.Sprint(colors) != fmt.Sprint(wantColors) || fmt.Sprint(counts) != fmt.Sprint(wantCounts) {
+		t.Errorf("sparse histogram differs from dense one")
+	}
+}
+
+func BenchmarkCountColors(b *testing.B) {
+	images := [][]palette.IntColor{
+		loadTestImage(b, "gatox01.png"),
+		loadTestImage(b, "gatox02.png"),
+		loadTestImage(b, "gatox01a.png"),
+		loadTestImage(b, "gatox02a.png"),
+	}
+	b.Run("cube", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			countColorsCube(images)
+		}
+	})
+	for _, bits := range []int{8, 6, 5} {
+		b.Run(fmt.Sprintf("bits%d", bits), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				countColors(images, nil, nil, bits)
+			}
+		})
+	}
+}
+
+func TestWeightUnits(t *testing.T) {
+	tests := []struct {
+		weights []float64
+		masked  bool
+		want    []uint64
+	}{
+		{nil, false, []uint64{1, 1}},
+		{nil, true, []uint64{weightScale, weightScale}},
+		{[]float64{2, 2}, false, []uint64{1, 1}},
+		{[]float64{0, 0}, false, []uint64{0, 0}},
+		{[]float64{1, 0}, false, []uint64{weightScale, 0}},
+		{[]float64{0.001, 1}, false, []uint64{weightScale, 1000 * weightScale}},
+		{[]float64{0.5}, false, []uint64{weightScale, 2 * weightScale}},
+		{[]float64{1e-9, 1e9}, false, []uint64{weightScale, maxWeightUnits}},
+	}
+	for _, test := range tests {
+		got := weightUnits(test.weights, 2, test.masked)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("weightUnits(%v, masked %t) = %v, want %v", test.weights, test.masked, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package quantize
+
+import (
+	"context"
+	"sort"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+// MedianCut splits color boxes at weighted median of their widest axis. Boxes
+// are measured in the selected color space.
+type MedianCut struct {
+	base
+}
+
+type weightedColor struct {
+	color palette.FloatColor
+	count uint64
+}
+
+type colorBox struct {
+	points []weightedColor
+	count  uint64
+	axis   int
+	width  float64
+}
+
+func component(color palette.FloatColor, axis int) float64 {
+	switch axis {
+	case 0:
+		return color.R
+	case 1:
+		return color.G
+	default:
+		return color.B
+	}
+}
+
+func newColorBox(points []weightedColor) colorBox {
+	box := colorBox{points: points}
+	for _, point := range points {
+		box.count += point.count
+	}
+	for axis := 0; axis < 3; axis++ {
+		low := component(points[0].color, axis)
+		high := low
+		for _, point := range points[1:] {
+			value := component(point.color, axis)
+			if value < low {
+				low = value
+			}
+			if value > high {
+				high = value
+			}
+		}
+		if high-low > box.width {
+			box.width = high - low
+			box.axis = axis
+		}
+	}
+	return box
+}
+
+// priority estimates squared error removed by splitting the box.
+func (box *colorBox) priority() float64 {
+	if len(box.points) < 2 {
+		return 0
+	}
+	return box.width * box.width * float64(box.count)
+}
+
+func (box *colorBox) split() (colorBox, colorBox) {
+	sort.Slice(box.points, func(i, j int) bool {
+		return component(box.points[i].color, box.axis) < component(box.points[j].color, box.axis)
+	})
+	half := box.count / 2
+	sum := uint64(0)
+	median := 1
+	for i, point := range box.points[:len(box.points)-1] {
+		sum += point.count
+		if sum >= half {
+			median = i + 1
+			break
+		}
+	}
+	return newColorBox(box.points[:median]), newColorBox(box.points[median:])
+}
+
+func (box *colorBox) mean() palette.FloatColor {
+	result := palette.FloatColor{}
+	for _, point := range box.points {
+		result.R += point.color.R * float64(point.count)
+		result.G += point.color.G * float64(point.count)
+		result.B += point.color.B * float64(point.count)
+	}
+	size := float64(box.count)
+	return palette.FloatColor{R: result.R / size, G: result.G / size, B: result.B / size}
+}
+
+func (q *MedianCut) RunContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.Run()
+	return nil
+}
+
+func (q *MedianCut) Run() {
+	points := make([]weightedColor, len(q.histColors))
+	for i, color := range q.histColors {
+		points[i] = weightedColor{q.space.Convert(color.ToFloatColor()), q.histCounts[i]}
+	}
+
+	boxes := []colorBox{newColorBox(points)}
+	for len(boxes) < q.freeColors() {
+		best := 0
+		for i := range boxes {
+			if boxes[i].priority() > boxes[best].priority() {
+				best = i
+			}
+		}
+		if boxes[best].priority() == 0 {
+			break
+		}
+		left, right := boxes[best].split()
+		boxes[best] = left
+		boxes = append(boxes, right)
+	}
+
+	free := make(palette.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		if len(free) < q.freeColors() {
+			free = append(free, q.space.Revert(box.mean()).ToIntColor().Normalized())
+		}
+	}
+	q.setResult(free)
+}
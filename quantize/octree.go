@@ -0,0 +1,169 @@
+package quantize
+
+import (
+	"context"
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+const octreeDepth = 8
+
+// Octree builds color octree and merges least used leaves until the number
+// of leaves fits into palette. Works in RGB.
+type Octree struct {
+	base
+
+	levels [octreeDepth][]*octreeNode
+	leaves int
+}
+
+type octreeNode struct {
+	children   [8]*octreeNode
+	r, g, b    uint64
+	count      uint64
+	childCount int
+	leaf       bool
+}
+
+func (q *Octree) insert(color palette.IntColor, count uint64) {
+	node := q.root()
+	for level := 0; level < octreeDepth; level++ {
+		shift := octreeDepth - 1 - level
+		child := (color.R>>shift&1)<<2 | (color.G>>shift&1)<<1 | (color.B >> shift & 1)
+		if node.children[child] == nil {
+			node.children[child] = &octreeNode{leaf: level == octreeDepth-1}
+			node.childCount++
+			if level < octreeDepth-1 {
+				q.levels[level+1] = append(q.levels[level+1], node.children[child])
+			} else {
+				q.leaves++
+			}
+		}
+		node = node.children[child]
+	}
+	node.r += uint64(color.R) * count
+	node.g += uint64(color.G) * count
+	node.b += uint64(color.B) * count
+	node.count += count
+}
+
+func (q *Octree) root() *octreeNode {
+	if len(q.levels[0]) == 0 {
+		q.levels[0] = append(q.levels[0], &octreeNode{})
+	}
+	return q.levels[0][0]
+}
+
+// fill sums pixels of subtree into inner nodes.
+func fill(node *octreeNode) {
+	if node.leaf {
+		return
+	}
+	for _, child := range node.children {
+		if child != nil {
+			fill(child)
+			node.r += child.r
+			node.g += child.g
+			node.b += child.b
+			node.count += child.count
+		}
+	}
+}
+
+// reduce turns one of the deepest inner nodes with fewest pixels into a leaf.
+// When that would drop the number of leaves below target, only its two
+// smallest leaves are merged.
+func (q *Octree) reduce(target int) {
+	level := octreeDepth - 1
+	for level > 0 && len(q.levels[level]) == 0 {
+		level--
+	}
+	nodes := q.levels[level]
+	best := -1
+	for i, node := range nodes {
+		if q.leaves-(node.childCount-1) < target {
+			continue
+		}
+		if best < 0 || node.count < nodes[best].count {
+			best = i
+		}
+	}
+	if best < 0 {
+		best = 0
+		for i, node := range nodes {
+			if node.count < nodes[best].count {
+				best = i
+			}
+		}
+		mergeSmallest(nodes[best])
+		q.leaves--
+		return
+	}
+	node := nodes[best]
+	nodes[best] = nodes[len(nodes)-1]
+	q.levels[level] = nodes[:len(nodes)-1]
+
+	node.leaf = true
+	node.children = [8]*octreeNode{}
+	q.leaves -= node.childCount - 1
+}
+
+// mergeSmallest merges two children with fewest pixels.
+func mergeSmallest(node *octreeNode) {
+	first, second := -1, -1
+	for i, child := range node.children {
+		switch {
+		case child == nil:
+		case first < 0 || child.count < node.children[first].count:
+			first, second = i, first
+		case second < 0 || child.count < node.children[second].count:
+			second = i
+		}
+	}
+	into := node.children[first]
+	from := node.children[second]
+	into.r += from.r
+	into.g += from.g
+	into.b += from.b
+	into.count += from.count
+	node.children[second] = nil
+	node.childCount--
+}
+
+func (q *Octree) collect(node *octreeNode, result palette.Palette) palette.Palette {
+	if node.leaf {
+		return append(result, palette.IntColor{
+			R: int(node.r / node.count),
+			G: int(node.g / node.count),
+			B: int(node.b / node.count)})
+	}
+	for _, child := range node.children {
+		if child != nil {
+			result = q.collect(child, result)
+		}
+	}
+	return result
+}
+
+func (q *Octree) RunContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.Run()
+	return nil
+}
+
+func (q *Octree) Run() {
+	for i, color := range q.histColors {
+		q.insert(color, q.histCounts[i])
+	}
+	root := q.root()
+	fill(root)
+	for q.leaves > q.freeColors() && !root.leaf {
+		q.reduce(q.freeColors())
+	}
+	free := make(palette.Palette, 0, q.leaves)
+	if q.freeColors() > 0 {
+		free = q.collect(root, free)
+	}
+	q.setResult(free)
+}
@@ -0,0 +1,467 @@
+package quantize
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+type ColorPoint struct {
+	color    palette.FloatColor
+	segment  int
+	count    uint64
+	distance float64
+}
+
+// FixedColor is a palette entry that PalCalc keeps at its index and never moves.
+type FixedColor struct {
+	Index int
+	Color palette.IntColor
+}
+
+type PalCalc struct {
+	points    []ColorPoint
+	centroids []palette.FloatColor
+	fixed     []FixedColor
+	bits      int
+	weights   []float64
+	masks     [][]uint8
+	initial   palette.Palette
+	space     palette.ColorSpace
+
+	colors    int
+	poinCount uint64
+
+	// movement is the largest centroid movement of the step in RGB units.
+	movement      float64
+	pointsChanged uint64
+
+	workers     int
+	pointRanges [][]ColorPoint
+
+	bestError   float64
+	bestPalette palette.Palette
+	bestAtt     int
+	errors      []float64
+	// bestErrors is the best error after every attempt.
+	bestErrors []float64
+
+	maxSteps    int
+	maxAttempt  int
+	tolerance   float64
+	improvement float64
+	timeLimit   time.Duration
+
+	seed     int64
+	rng      *rand.Rand
+	reporter Reporter
+}
+
+func swapPoints(left, right *ColorPoint) {
+	*left, *right = *right, *left
+}
+
+// improvementWindow is number of attempts over which the best error must
+// improve by the relative improvement threshold.
+const improvementWindow = 3
+
+func NewPalCalc(colors int, steps int, attempt int) *PalCalc {
+	km := &PalCalc{colors: colors, maxSteps: steps, maxAttempt: attempt}
+	km.reporter = &ConsoleReporter{os.Stdout}
+	km.SetSeed(time.Now().UnixNano())
+	return km
+}
+
+// SetTolerance sets convergence thresholds: attempt stops when no centroid moves
+// by movement or more in a step (measured in RGB from 0 to 255 whatever the
+// color space is), attempts stop when the best error improves relatively less
+// than improvement over the last improvementWindow attempts. Zero disables a
+// threshold.
+func (km *PalCalc) SetTolerance(movement float64, improvement float64) {
+	km.tolerance = movement
+	km.improvement = improvement
+}
+
+// SetTimeLimit stops calculation after given time, keeping the best palette so far.
+// Zero means no limit.
+func (km *PalCalc) SetTimeLimit(limit time.Duration) {
+	km.timeLimit = limit
+}
+
+// SetHistogramBits reduces precision of input colors to given bits per channel.
+// Must be called before Input.
+func (km *PalCalc) SetHistogramBits(bits int) {
+	km.bits = bits
+}
+
+// SetWeights scales contribution of every input image, nil means equal weights.
+// Must be called before Input.
+func (km *PalCalc) SetWeights(weights []float64) {
+	km.weights = weights
+}
+
+// SetMasks scales contribution of every pixel by its mask value from 0 to 255,
+// nil mask keeps full contribution. Must be called before Input.
+func (km *PalCalc) SetMasks(masks [][]uint8) {
+	km.masks = masks
+}
+
+// SetWorkers sets number of goroutines used for calculation, all CPUs if zero.
+// Must be called before Input.
+func (km *PalCalc) SetWorkers(workers int) {
+	km.workers = workers
+}
+
+// SetReporter sets receiver of calculation progress and messages, console by default.
+func (km *PalCalc) SetReporter(reporter Reporter) {
+	km.reporter = reporter
+}
+
+// SetSeed makes calculation reproducible: the same input and seed give the same palette.
+func (km *PalCalc) SetSeed(seed int64) {
+	km.seed = seed
+	km.rng = rand.New(rand.NewSource(seed))
+}
+
+// SetFixed pins colors to palette indices. The rest of the palette is optimized
+// around them. Must be called before Input.
+func (km *PalCalc) SetFixed(fixed []FixedColor) {
+	km.fixed = fixed
+}
+
+// SetInitial seeds free centroids with given colors instead of random points.
+func (km *PalCalc) SetInitial(colors palette.Palette) {
+	km.initial = colors
+}
+
+// SetColorSpace selects how color distance is measured. Clustering is done in
+// the converted space. Must be called before Input.
+func (km *PalCalc) SetColorSpace(space palette.ColorSpace) {
+	km.space = space
+}
+
+func (km *PalCalc) Input(images [][]palette.IntColor) error {
+	colors, counts := countColors(images, km.weights, km.masks, km.bits)
+	var err error
+	km.colors, err = checkColors(km.reporter, len(colors), km.colors, km.fixed)
+	if err != nil {
+		return err
+	}
+	km.setPoints(colors, counts)
+	return nil
+}
+
+func (km *PalCalc) setPoints(colors []palette.IntColor, counts []uint64) {
+	km.poinCount = uint64(len(colors))
+
+	km.points = make([]ColorPoint, len(colors))
+	for i, color := range colors {
+		km.points[i] = ColorPoint{
+			color:    km.space.Convert(color.ToFloatColor()),
+			segment:  0,
+			count:    counts[i],
+			distance: math.MaxFloat64}
+	}
+
+	workers := km.workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	km.pointRanges = make([][]ColorPoint, workers)
+	rangeSize := len(km.points) / workers
+	for i := 0; i < workers-1; i++ {
+		km.pointRanges[i] = km.points[i*rangeSize : (i+1)*rangeSize]
+	}
+	km.pointRanges[workers-1] = km.points[(workers-1)*rangeSize:]
+}
+
+func (point *ColorPoint) pointDistance(space palette.ColorSpace, center palette.FloatColor) float64 {
+	dist := space.Distance(point.color, center)
+	if dist < point.distance {
+		point.distance = dist
+		return dist
+	}
+	return point.distance
+}
+
+// initCentroids picks centroids with k-means++. When ctx is canceled the rest
+// of free centroids are taken from input colors as they are.
+func (km *PalCalc) initCentroids(ctx context.Context) {
+	// fixed and initial centroids go first
+	km.centroids = make([]palette.FloatColor, 0, km.colors)
+	for _, fixed := range km.fixed {
+		km.centroids = append(km.centroids, km.space.Convert(fixed.Color.ToFloatColor()))
+	}
+	for _, color := range km.initial {
+		if len(km.centroids) < km.colors {
+			km.centroids = append(km.centroids, km.space.Convert(color.ToFloatColor()))
+		}
+	}
+
+	freeCount := uint64(km.colors - len(km.centroids))
+	for i := range km.points {
+		km.points[i].distance = math.MaxFloat64
+	}
+	for _, center := range km.centroids {
+		if ctx.Err() != nil {
+			break
+		}
+		for i := range km.points {
+			km.points[i].pointDistance(km.space, center)
+		}
+	}
+
+	// chosen centroids are moved to the beginning of points
+	chosen := uint64(0)
+	if len(km.centroids) == 0 && freeCount > 0 {
+		swapPoints(&km.points[0], &km.points[km.rng.Uint64()%km.poinCount])
+		chosen = 1
+	}
+	for chosen < freeCount && ctx.Err() == nil {
+		var sum float64 = 0
+		for i := chosen; i < km.poinCount; i++ {
+			if chosen > 0 {
+				km.points[i].pointDistance(km.space, km.points[chosen-1].color)
+			}
+			sum += km.points[i].distance
+		}
+		rnd := km.rng.Float64() * sum
+		sum = 0
+		next := km.poinCount - 1
+		for i := chosen; i < km.poinCount; i++ {
+			sum += km.points[i].distance
+			if sum > rnd {
+				next = i
+				break
+			}
+		}
+		swapPoints(&km.points[chosen], &km.points[next])
+		chosen++
+	}
+
+	for i := uint64(0); i < freeCount; i++ {
+		km.centroids = append(km.centroids, km.points[i].color)
+	}
+}
+
+func (km *PalCalc) calcCentroids() {
+	//start := time.Now()
+	newCentroids := make([]palette.FloatColor, km.colors)
+	sizes := make([]uint64, km.colors)
+	for _, point := range km.points {
+		sizes[point.segment] += point.count
+		c := &newCentroids[point.segment]
+		c.R += point.color.R * float64(point.count)
+		c.G += point.color.G * float64(point.count)
+		c.B += point.color.B * float64(point.count)
+	}
+	km.movement = 0
+	for i := len(km.fixed); i < len(km.centroids); i++ {
+		if sizes[i] == 0 {
+			continue
+		}
+		size := float64(sizes[i])
+		newCentroids[i].R /= size
+		newCentroids[i].G /= size
+		newCentroids[i].B /= size
+		// measured in RGB, so tolerance does not depend on color space
+		moved := km.space.Revert(newCentroids[i]).Distance(km.space.Revert(km.centroids[i]))
+		km.movement = math.Max(km.movement, math.Sqrt(moved)*255)
+		km.centroids[i] = newCentroids[i]
+	}
+	//fmt.Printf("Centroids: %s   ", time.Since(start))
+}
+
+// calcSegments assigns points to nearest centroids. It stops early when ctx is
+// canceled, leaving the rest of points in their old segments.
+func (km *PalCalc) calcSegments(ctx context.Context) {
+	var (
+		mt sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	//start := time.Now()
+	km.pointsChanged = 0
+	for _, task := range km.pointRanges {
+		wg.Add(1)
+		go func(chunk []ColorPoint) {
+			for i := range chunk {
+				if i%4096 == 0 && ctx.Err() != nil {
+					break
+				}
+				oldSeg := chunk[i].segment
+				newSeg := oldSeg
+				minDist := km.space.Distance(chunk[i].color, km.centroids[oldSeg])
+				for c := range km.centroids {
+					dist := km.space.Distance(chunk[i].color, km.centroids[c])
+					if dist < minDist {
+						minDist = dist
+						newSeg = c
+					}
+				}
+				if oldSeg != newSeg {
+					chunk[i].segment = newSeg
+					mt.Lock()
+					km.pointsChanged++
+					mt.Unlock()
+				}
+			}
+			wg.Done()
+		}(task)
+	}
+	wg.Wait()
+
+	//fmt.Printf("SegmentsMt: %s\n", time.Since(start))
+}
+
+func (km *PalCalc) report(attempt int, step int, start time.Time) {
+	elapsed := time.Since(start)
+	remainingSteps := km.maxSteps*km.maxAttempt - step - (attempt-1)*km.maxSteps
+	remaining := elapsed * time.Duration(remainingSteps) / time.Duration(step+(attempt-1)*km.maxSteps)
+	km.reporter.Progress(Progress{
+		Attempt:   attempt,
+		Attempts:  km.maxAttempt,
+		Step:      step,
+		Steps:     km.maxSteps,
+		Distance:  km.movement,
+		Changed:   km.pointsChanged,
+		Elapsed:   elapsed,
+		Remaining: remaining,
+	})
+}
+
+func (km *PalCalc) CalcError() float64 {
+	score := float64(0)
+	for _, point := range km.points {
+		score += math.Sqrt(km.space.Distance(point.color, km.centroids[point.segment])) * float64(point.count)
+	}
+	return score
+}
+
+func (km *PalCalc) timeIsOut(start time.Time) bool {
+	return km.timeLimit > 0 && time.Since(start) >= km.timeLimit
+}
+
+func (km *PalCalc) Run() {
+	km.RunContext(context.Background())
+}
+
+// RunContext calculates palette until done or ctx is canceled. On cancellation
+// the best palette found so far is kept and ctx error is returned.
+func (km *PalCalc) RunContext(ctx context.Context) error {
+	km.errors = make([]float64, 0, km.maxAttempt)
+	km.bestErrors = make([]float64, 0, km.maxAttempt)
+	summary := Summary{Seed: km.seed}
+	startTime := time.Now()
+	for a := 1; a < km.maxAttempt+1; a++ {
+		km.initCentroids(ctx)
+		steps := 0
+		for i := 1; i < km.maxSteps+1; i++ {
+			steps = i
+			if ctx.Err() != nil {
+				break
+			}
+			km.calcSegments(ctx)
+			if km.pointsChanged == 0 {
+				km.report(a, i, startTime)
+				break
+			}
+			km.calcCentroids()
+			km.report(a, i, startTime)
+			if km.movement < km.tolerance || km.timeIsOut(startTime) {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			if a == 1 {
+				// unfinished attempt still gives usable palette
+				km.bestAtt = a
+				km.bestPalette = km.calcPalette()
+			}
+			summary.Stopped = "Canceled"
+			break
+		}
+		km.calcSegments(ctx)
+		colorErr := km.CalcError()
+		if a == 1 || colorErr < km.bestError {
+			km.bestAtt = a
+			km.bestError = colorErr
+			km.bestPalette = km.calcPalette()
+		}
+		km.errors = append(km.errors, colorErr)
+		km.bestErrors = append(km.bestErrors, km.bestError)
+		km.reporter.Progress(Progress{
+			Attempt:  a,
+			Attempts: km.maxAttempt,
+			Step:     steps,
+			Steps:    km.maxSteps,
+			Elapsed:  time.Since(startTime),
+			Finished: true,
+			Error:    colorErr,
+		})
+		if ctx.Err() != nil {
+			summary.Stopped = "Canceled"
+			break
+		}
+		if km.timeIsOut(startTime) {
+			summary.Stopped = "Time limit reached"
+			break
+		}
+		if km.improvement > 0 && a > improvementWindow && a < km.maxAttempt {
+			prevError := km.bestErrors[a-1-improvementWindow]
+			if prevError-km.bestError < km.improvement*prevError {
+				summary.Stopped = "No significant improvement"
+				break
+			}
+		}
+	}
+	summary.BestAttempt = km.bestAtt
+	summary.Errors = km.errors
+	km.reporter.Done(summary)
+	return ctx.Err()
+}
+
+func (km *PalCalc) calcPalette() palette.Palette {
+	free := make(palette.Palette, 0, km.colors)
+	for _, c := range km.centroids[len(km.fixed):] {
+		free = append(free, km.space.Revert(c).ToIntColor().Normalized())
+	}
+	free.Sort()
+	return layoutPalette(km.fixed, free, km.colors)
+}
+
+// layoutPalette puts fixed colors at their indices and fills other slots with free colors.
+func layoutPalette(fixed []FixedColor, free palette.Palette, size int) palette.Palette {
+	for _, f := range fixed {
+		if f.Index >= size {
+			size = f.Index + 1
+		}
+	}
+	result := make(palette.Palette, size)
+	used := make([]bool, size)
+	for _, f := range fixed {
+		result[f.Index] = f.Color
+		used[f.Index] = true
+	}
+	next := 0
+	for i := range result {
+		if used[i] {
+			continue
+		}
+		if next < len(free) {
+			result[i] = free[next]
+			next++
+		}
+	}
+	return result
+}
+
+func (km *PalCalc) GetPalette() palette.Palette {
+	return km.bestPalette
+}
@@ -0,0 +1,108 @@
+package quantize
+
+import (
+	"image"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+func loadTestImage(t testing.TB, name string) []palette.IntColor {
+	file, err := os.Open(filepath.Join("..", "test_assets", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := img.Bounds()
+	result := make([]palette.IntColor, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			result = append(result, palette.IntColor{R: int(r >> 8), G: int(g >> 8), B: int(b >> 8)})
+		}
+	}
+	return result
+}
+
+func runPalCalc(t *testing.T, images [][]palette.IntColor, workers int) (palette.Palette, float64) {
+	km := NewPalCalc(16, 20, 2)
+	km.SetWorkers(workers)
+	km.SetSeed(1)
+	km.SetReporter(silentReporter{})
+	if err := km.Input(images); err != nil {
+		t.Fatal(err)
+	}
+	km.Run()
+	return km.GetPalette(), km.bestError
+}
+
+func TestPalCalcWorkers(t *testing.T) {
+	images := [][]palette.IntColor{loadTestImage(t, "gatox01.png")}
+	want, wantError := runPalCalc(t, images, 1)
+	for _, workers := range []int{runtime.NumCPU(), 3, 8} {
+		got, gotError := runPalCalc(t, images, workers)
+		if len(got) != len(want) {
+			t.Fatalf("%d workers: %d colors, want %d", workers, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%d workers: color %d is %v, want %v", workers, i, got[i], want[i])
+			}
+		}
+		if gotError != wantError {
+			t.Errorf("%d workers: error %g, want %g", workers, gotError, wantError)
+		}
+	}
+}
+
+type progressRecorder struct {
+	silentReporter
+	steps []Progress
+}
+
+func (r *progressRecorder) Progress(p Progress) {
+	if !p.Finished {
+		r.steps = append(r.steps, p)
+	}
+}
+
+func TestPalCalcTolerance(t *testing.T) {
+	images := [][]palette.IntColor{loadTestImage(t, "gatox01.png")}
+	spaces := []palette.ColorSpace{palette.ColorSpaceRGB, palette.ColorSpaceRedmean, palette.ColorSpaceLab, palette.ColorSpaceLab2000, palette.ColorSpaceOKLab}
+	const tolerance = 1
+	for _, space := range spaces {
+		for _, colors := range []int{4, 32} {
+			recorder := &progressRecorder{}
+			km := NewPalCalc(colors, 1000, 1)
+			km.SetHistogramBits(4)
+			km.SetColorSpace(space)
+			km.SetTolerance(tolerance, 0)
+			km.SetSeed(1)
+			km.SetReporter(recorder)
+			if err := km.Input(images); err != nil {
+				t.Fatal(err)
+			}
+			km.Run()
+			for i, p := range recorder.steps {
+				last := i == len(recorder.steps)-1
+				switch {
+				case p.Distance > 255*math.Sqrt(3):
+					t.Errorf("%s, %d colors: step %d moved by %g", space, colors, p.Step, p.Distance)
+				case last && p.Distance >= tolerance && p.Changed > 0:
+					t.Errorf("%s, %d colors: stopped at step %d moving by %g", space, colors, p.Step, p.Distance)
+				case !last && p.Distance < tolerance:
+					t.Errorf("%s, %d colors: step %d moved by %g, but did not stop", space, colors, p.Step, p.Distance)
+				}
+			}
+		}
+	}
+}
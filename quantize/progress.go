@@ -0,0 +1,140 @@
+package quantize
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Progress is state of k-means calculation after a step.
+type Progress struct {
+	Attempt  int
+	Attempts int
+	Step     int
+	Steps    int
+	// Distance is the largest centroid movement of the step in RGB units (0..255).
+	Distance float64
+	// Changed is number of points moved to another centroid.
+	Changed   uint64
+	Elapsed   time.Duration
+	Remaining time.Duration
+	// Finished is set after the last step of attempt, Error is its result.
+	Finished bool
+	Error    float64
+}
+
+// Summary is result of k-means calculation.
+type Summary struct {
+	Seed        int64
+	BestAttempt int
+	Errors      []float64
+	// Stopped explains why calculation stopped before all attempts, empty otherwise.
+	Stopped string
+}
+
+// Reporter receives progress of palette calculation and messages about other
+// stages of texture pack building.
+type Reporter interface {
+	Progress(progress Progress)
+	Done(summary Summary)
+	Message(text string)
+}
+
+func formatTime(dur time.Duration) string {
+	var result strings.Builder
+	d := dur.Round(time.Second)
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	if h > 0 {
+		fmt.Fprintf(&result, "%2d h ", int(h))
+	} else {
+		fmt.Fprint(&result, "     ")
+	}
+	if m > 0 {
+		fmt.Fprintf(&result, "%2d m ", int(m))
+	} else {
+		fmt.Fprint(&result, "     ")
+	}
+	fmt.Fprintf(&result, "%2d s", int(s))
+	return result.String()
+}
+
+// ConsoleReporter rewrites single status line on terminal.
+type ConsoleReporter struct {
+	Output io.Writer
+}
+
+func (r *ConsoleReporter) Progress(p Progress) {
+	if p.Finished {
+		return
+	}
+	fmt.Fprintf(r.Output, "\r Att %2d / %d | Step %4d / %d | Dist %10.5g | Ch %10d | El %s | Rem %s  ",
+		p.Attempt,
+		p.Attempts,
+		p.Step,
+		p.Steps,
+		p.Distance,
+		p.Changed,
+		formatTime(p.Elapsed),
+		formatTime(p.Remaining))
+}
+
+func (r *ConsoleReporter) Message(text string) {
+	fmt.Fprintln(r.Output, text)
+}
+
+func (r *ConsoleReporter) Done(s Summary) {
+	if s.Stopped != "" {
+		fmt.Fprintf(r.Output, "\n%s", s.Stopped)
+	}
+	fmt.Fprintf(r.Output, "\nMost successful attempt is %d (seed %d)\n", s.BestAttempt, s.Seed)
+	fmt.Fprintln(r.Output, s.Errors)
+}
+
+// LogReporter writes a line per finished attempt, suitable for logs.
+type LogReporter struct {
+	Output io.Writer
+}
+
+func (r *LogReporter) Progress(p Progress) {
+	if !p.Finished {
+		return
+	}
+	fmt.Fprintf(r.Output, "Attempt %d/%d: %d steps, error %.6g, elapsed %s\n",
+		p.Attempt, p.Attempts, p.Step, p.Error, p.Elapsed.Round(time.Millisecond))
+}
+
+func (r *LogReporter) Message(text string) {
+	fmt.Fprintln(r.Output, text)
+}
+
+func (r *LogReporter) Done(s Summary) {
+	if s.Stopped != "" {
+		fmt.Fprintln(r.Output, s.Stopped)
+	}
+	fmt.Fprintf(r.Output, "Most successful attempt is %d (seed %d)\n", s.BestAttempt, s.Seed)
+}
+
+type silentReporter struct{}
+
+func (silentReporter) Progress(Progress) {}
+func (silentReporter) Done(Summary)      {}
+func (silentReporter) Message(string)    {}
+
+// GetReporter returns reporter by name: console, log or none.
+func GetReporter(name string, output io.Writer) (Reporter, error) {
+	switch name {
+	case "console":
+		return &ConsoleReporter{output}, nil
+	case "log":
+		return &LogReporter{output}, nil
+	case "none":
+		return silentReporter{}, nil
+	default:
+		return nil, fmt.Errorf("progress reporter \"%s\" does not exist", name)
+	}
+}
@@ -0,0 +1,197 @@
+package quantize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+// Quantizer calculates palette for a set of images.
+type Quantizer interface {
+	// SetFixed pins colors to palette indices. Must be called before Input.
+	SetFixed(fixed []FixedColor)
+	// SetColorSpace selects how color distance is measured. Must be called before Input.
+	SetColorSpace(space palette.ColorSpace)
+	// SetWeights scales contribution of every input image, nil means equal
+	// weights. Must be called before Input.
+	SetWeights(weights []float64)
+	// SetMasks scales contribution of every pixel by its mask value from 0 to
+	// 255. Masks follow input images, nil mask keeps full contribution. Must be
+	// called before Input.
+	SetMasks(masks [][]uint8)
+	// SetSeed sets seed of random number generator. Must be called before Run.
+	SetSeed(seed int64)
+	Input(images [][]palette.IntColor) error
+	// RunContext calculates palette. It stops early and returns ctx error when
+	// ctx is canceled.
+	RunContext(ctx context.Context) error
+	GetPalette() palette.Palette
+}
+
+type Method int
+
+const (
+	MethodKMeans Method = iota
+	MethodMedianCut
+	MethodOctree
+	MethodWu
+	MethodWuKMeans
+)
+
+var methodNames = []string{"kmeans", "mediancut", "octree", "wu", "wukmeans"}
+
+func GetMethod(name string) (Method, error) {
+	for i, methodName := range methodNames {
+		if methodName == name {
+			return Method(i), nil
+		}
+	}
+	return MethodKMeans, fmt.Errorf("quantizer \"%s\" does not exist", name)
+}
+
+func (method Method) String() string {
+	if int(method) < len(methodNames) {
+		return methodNames[method]
+	}
+	return fmt.Sprintf("Method(%d)", int(method))
+}
+
+// Settings control palette calculation. All fields except HistogramBits are
+// used only by k-means.
+type Settings struct {
+	// HistogramBits is precision of color histogram in bits per channel, 8 if zero.
+	HistogramBits int
+
+	Steps    int
+	Attempts int
+	// Tolerance stops attempt when no centroid moves by it or more in a step.
+	// Movement is measured in RGB units from 0 to 255 in every color space.
+	Tolerance float64
+	// Improvement stops attempts when the best error improves relatively less
+	// than it over the last 3 attempts.
+	Improvement float64
+	// TimeLimit stops calculation after given time, zero means no limit.
+	TimeLimit time.Duration
+	// Workers is number of goroutines, all CPUs if zero.
+	Workers int
+	// Reporter receives progress and messages, console if nil.
+	Reporter Reporter
+}
+
+func DefaultSettings() Settings {
+	return Settings{Steps: 1000, Attempts: 10}
+}
+
+// New creates quantizer.
+func New(method Method, colors int, settings Settings) Quantizer {
+	reporter := settings.Reporter
+	if reporter == nil {
+		reporter = &ConsoleReporter{os.Stdout}
+	}
+	switch method {
+	case MethodMedianCut:
+		return &MedianCut{base: base{colors: colors, bits: settings.HistogramBits, reporter: reporter}}
+	case MethodOctree:
+		return &Octree{base: base{colors: colors, bits: settings.HistogramBits, reporter: reporter}}
+	case MethodWu:
+		return &Wu{base: base{colors: colors, bits: settings.HistogramBits, reporter: reporter}}
+	case MethodWuKMeans:
+		km := NewWuKMeans(colors, settings.Steps)
+		km.SetHistogramBits(settings.HistogramBits)
+		km.SetTolerance(settings.Tolerance, 0)
+		km.SetTimeLimit(settings.TimeLimit)
+		km.SetWorkers(settings.Workers)
+		km.SetReporter(reporter)
+		return km
+	default:
+		km := NewPalCalc(colors, settings.Steps, settings.Attempts)
+		km.SetHistogramBits(settings.HistogramBits)
+		km.SetTolerance(settings.Tolerance, settings.Improvement)
+		km.SetTimeLimit(settings.TimeLimit)
+		km.SetWorkers(settings.Workers)
+		km.SetReporter(reporter)
+		return km
+	}
+}
+
+// checkColors returns number of palette colors to calculate: it is reduced when
+// images have fewer distinct colors than free palette slots.
+func checkColors(reporter Reporter, total int, colors int, fixed []FixedColor) (int, error) {
+	reporter.Message(fmt.Sprintf("Total number of colors: %d", total))
+	if total == 0 {
+		return 0, errors.New("no colors in input images")
+	}
+	if len(fixed) > colors {
+		return 0, fmt.Errorf("too many fixed colors (%d of %d)", len(fixed), colors)
+	}
+	if colors-len(fixed) > total {
+		return total + len(fixed), nil
+	}
+	return colors, nil
+}
+
+// base holds color histogram and settings shared by box-splitting quantizers.
+type base struct {
+	colors  int
+	bits    int
+	weights []float64
+	masks   [][]uint8
+	fixed   []FixedColor
+	space   palette.ColorSpace
+
+	reporter Reporter
+
+	histColors []palette.IntColor
+	histCounts []uint64
+
+	// free are calculated colors without fixed ones.
+	free   palette.Palette
+	result palette.Palette
+}
+
+func (q *base) SetFixed(fixed []FixedColor) {
+	q.fixed = fixed
+}
+
+func (q *base) SetColorSpace(space palette.ColorSpace) {
+	q.space = space
+}
+
+func (q *base) SetWeights(weights []float64) {
+	q.weights = weights
+}
+
+func (q *base) SetMasks(masks [][]uint8) {
+	q.masks = masks
+}
+
+// SetSeed does nothing: box-splitting quantizers are deterministic.
+func (q *base) SetSeed(seed int64) {
+}
+
+func (q *base) Input(images [][]palette.IntColor) error {
+	q.histColors, q.histCounts = countColors(images, q.weights, q.masks, q.bits)
+	var err error
+	q.colors, err = checkColors(q.reporter, len(q.histColors), q.colors, q.fixed)
+	return err
+}
+
+// freeColors is number of palette colors that are not fixed.
+func (q *base) freeColors() int {
+	return q.colors - len(q.fixed)
+}
+
+// setResult sorts calculated colors and lays them out around fixed ones.
+func (q *base) setResult(free palette.Palette) {
+	free.Sort()
+	q.free = free
+	q.result = layoutPalette(q.fixed, free, q.colors)
+}
+
+func (q *base) GetPalette() palette.Palette {
+	return q.result
+}
@@ -0,0 +1,314 @@
+package quantize
+
+import (
+	"context"
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+// Wu is Xiaolin Wu's quantizer: color boxes are split to minimize variance
+// using cumulative moments over 5-bit RGB histogram. Works in RGB.
+type Wu struct {
+	base
+
+	// boxes are colors of boxes in calculation order, WuKMeans starts from them.
+	boxes palette.Palette
+}
+
+const (
+	wuBits = 5
+	wuSize = 1<<wuBits + 1
+)
+
+type wuMoments struct {
+	weight [wuSize][wuSize][wuSize]int64
+	r      [wuSize][wuSize][wuSize]int64
+	g      [wuSize][wuSize][wuSize]int64
+	b      [wuSize][wuSize][wuSize]int64
+	square [wuSize][wuSize][wuSize]float64
+}
+
+type wuBox struct {
+	r0, r1 int
+	g0, g1 int
+	b0, b1 int
+	volume int
+}
+
+const (
+	wuRed = iota
+	wuGreen
+	wuBlue
+)
+
+func (m *wuMoments) add(color palette.IntColor, count uint64) {
+	r := color.R>>(8-wuBits) + 1
+	g := color.G>>(8-wuBits) + 1
+	b := color.B>>(8-wuBits) + 1
+	c := int64(count)
+	m.weight[r][g][b] += c
+	m.r[r][g][b] += int64(color.R) * c
+	m.g[r][g][b] += int64(color.G) * c
+	m.b[r][g][b] += int64(color.B) * c
+	m.square[r][g][b] += float64(color.R*color.R+color.G*color.G+color.B*color.B) * float64(count)
+}
+
+// accumulate converts histogram into cumulative moments.
+func (m *wuMoments) accumulate() {
+	for r := 1; r < wuSize; r++ {
+		var area, areaR, areaG, areaB [wuSize]int64
+		var areaSquare [wuSize]float64
+		for g := 1; g < wuSize; g++ {
+			var line, lineR, lineG, lineB int64
+			var lineSquare float64
+			for b := 1; b < wuSize; b++ {
+				line += m.weight[r][g][b]
+				lineR += m.r[r][g][b]
+				lineG += m.g[r][g][b]
+				lineB += m.b[r][g][b]
+				lineSquare += m.square[r][g][b]
+				area[b] += line
+				areaR[b] += lineR
+				areaG[b] += lineG
+				areaB[b] += lineB
+				areaSquare[b] += lineSquare
+				m.weight[r][g][b] = m.weight[r-1][g][b] + area[b]
+				m.r[r][g][b] = m.r[r-1][g][b] + areaR[b]
+				m.g[r][g][b] = m.g[r-1][g][b] + areaG[b]
+				m.b[r][g][b] = m.b[r-1][g][b] + areaB[b]
+				m.square[r][g][b] = m.square[r-1][g][b] + areaSquare[b]
+			}
+		}
+	}
+}
+
+func volume(box *wuBox, m *[wuSize][wuSize][wuSize]int64) int64 {
+	return m[box.r1][box.g1][box.b1] - m[box.r1][box.g1][box.b0] -
+		m[box.r1][box.g0][box.b1] + m[box.r1][box.g0][box.b0] -
+		m[box.r0][box.g1][box.b1] + m[box.r0][box.g1][box.b0] +
+		m[box.r0][box.g0][box.b1] - m[box.r0][box.g0][box.b0]
+}
+
+func volumeFloat(box *wuBox, m *[wuSize][wuSize][wuSize]float64) float64 {
+	return m[box.r1][box.g1][box.b1] - m[box.r1][box.g1][box.b0] -
+		m[box.r1][box.g0][box.b1] + m[box.r1][box.g0][box.b0] -
+		m[box.r0][box.g1][box.b1] + m[box.r0][box.g1][box.b0] +
+		m[box.r0][box.g0][box.b1] - m[box.r0][box.g0][box.b0]
+}
+
+// bottom is the part of volume that does not depend on cut position.
+func bottom(box *wuBox, dir int, m *[wuSize][wuSize][wuSize]int64) int64 {
+	switch dir {
+	case wuRed:
+		return -m[box.r0][box.g1][box.b1] + m[box.r0][box.g1][box.b0] +
+			m[box.r0][box.g0][box.b1] - m[box.r0][box.g0][box.b0]
+	case wuGreen:
+		return -m[box.r1][box.g0][box.b1] + m[box.r1][box.g0][box.b0] +
+			m[box.r0][box.g0][box.b1] - m[box.r0][box.g0][box.b0]
+	default:
+		return -m[box.r1][box.g1][box.b0] + m[box.r1][box.g0][box.b0] +
+			m[box.r0][box.g1][box.b0] - m[box.r0][box.g0][box.b0]
+	}
+}
+
+// top is the part of volume that depends on cut position.
+func top(box *wuBox, dir int, pos int, m *[wuSize][wuSize][wuSize]int64) int64 {
+	switch dir {
+	case wuRed:
+		return m[pos][box.g1][box.b1] - m[pos][box.g1][box.b0] -
+			m[pos][box.g0][box.b1] + m[pos][box.g0][box.b0]
+	case wuGreen:
+		return m[box.r1][pos][box.b1] - m[box.r1][pos][box.b0] -
+			m[box.r0][pos][box.b1] + m[box.r0][pos][box.b0]
+	default:
+		return m[box.r1][box.g1][pos] - m[box.r1][box.g0][pos] -
+			m[box.r0][box.g1][pos] + m[box.r0][box.g0][pos]
+	}
+}
+
+func (m *wuMoments) variance(box *wuBox) float64 {
+	squares := sumSquares(volume(box, &m.r), volume(box, &m.g), volume(box, &m.b))
+	return volumeFloat(box, &m.square) - squares/float64(volume(box, &m.weight))
+}
+
+func sumSquares(r, g, b int64) float64 {
+	fr, fg, fb := float64(r), float64(g), float64(b)
+	return fr*fr + fg*fg + fb*fb
+}
+
+// maximize finds cut position along dir that maximizes between-box variance.
+func (m *wuMoments) maximize(box *wuBox, dir int, first int, last int, whole [4]int64) (float64, int) {
+	baseR := bottom(box, dir, &m.r)
+	baseG := bottom(box, dir, &m.g)
+	baseB := bottom(box, dir, &m.b)
+	baseW := bottom(box, dir, &m.weight)
+	max := 0.0
+	cut := -1
+	for i := first; i < last; i++ {
+		halfR := baseR + top(box, dir, i, &m.r)
+		halfG := baseG + top(box, dir, i, &m.g)
+		halfB := baseB + top(box, dir, i, &m.b)
+		halfW := baseW + top(box, dir, i, &m.weight)
+		if halfW == 0 || halfW == whole[3] {
+			continue
+		}
+		temp := sumSquares(halfR, halfG, halfB) / float64(halfW)
+		halfR = whole[0] - halfR
+		halfG = whole[1] - halfG
+		halfB = whole[2] - halfB
+		halfW = whole[3] - halfW
+		temp += sumSquares(halfR, halfG, halfB) / float64(halfW)
+		if temp > max {
+			max = temp
+			cut = i
+		}
+	}
+	return max, cut
+}
+
+func (m *wuMoments) cut(box1 *wuBox, box2 *wuBox) bool {
+	whole := [4]int64{volume(box1, &m.r), volume(box1, &m.g), volume(box1, &m.b), volume(box1, &m.weight)}
+	maxR, cutR := m.maximize(box1, wuRed, box1.r0+1, box1.r1, whole)
+	maxG, cutG := m.maximize(box1, wuGreen, box1.g0+1, box1.g1, whole)
+	maxB, cutB := m.maximize(box1, wuBlue, box1.b0+1, box1.b1, whole)
+
+	*box2 = wuBox{r0: box1.r0, r1: box1.r1, g0: box1.g0, g1: box1.g1, b0: box1.b0, b1: box1.b1}
+	switch {
+	case maxR >= maxG && maxR >= maxB:
+		if cutR < 0 {
+			return false
+		}
+		box1.r1, box2.r0 = cutR, cutR
+	case maxG >= maxR && maxG >= maxB:
+		box1.g1, box2.g0 = cutG, cutG
+	default:
+		box1.b1, box2.b0 = cutB, cutB
+	}
+	box1.volume = (box1.r1 - box1.r0) * (box1.g1 - box1.g0) * (box1.b1 - box1.b0)
+	box2.volume = (box2.r1 - box2.r0) * (box2.g1 - box2.g0) * (box2.b1 - box2.b0)
+	return true
+}
+
+// quantize splits the whole color cube into at most count boxes and returns their mean colors.
+func (m *wuMoments) quantize(count int) palette.Palette {
+	if count < 1 {
+		return palette.Palette{}
+	}
+	boxes := make([]wuBox, count)
+	variances := make([]float64, count)
+	boxes[0] = wuBox{r1: wuSize - 1, g1: wuSize - 1, b1: wuSize - 1}
+	used := 1
+	next := 0
+	for used < count {
+		if m.cut(&boxes[next], &boxes[used]) {
+			variances[next] = 0
+			if boxes[next].volume > 1 {
+				variances[next] = m.variance(&boxes[next])
+			}
+			variances[used] = 0
+			if boxes[used].volume > 1 {
+				variances[used] = m.variance(&boxes[used])
+			}
+			used++
+		} else {
+			variances[next] = 0
+		}
+		next = 0
+		for i := 1; i < used; i++ {
+			if variances[i] > variances[next] {
+				next = i
+			}
+		}
+		if variances[next] <= 0 {
+			break
+		}
+	}
+
+	result := make(palette.Palette, 0, used)
+	for i := range boxes[:used] {
+		weight := volume(&boxes[i], &m.weight)
+		if weight == 0 {
+			continue
+		}
+		result = append(result, palette.IntColor{
+			R: int(volume(&boxes[i], &m.r) / weight),
+			G: int(volume(&boxes[i], &m.g) / weight),
+			B: int(volume(&boxes[i], &m.b) / weight)})
+	}
+	return result
+}
+
+func (q *Wu) RunContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.Run()
+	return nil
+}
+
+func (q *Wu) Run() {
+	m := &wuMoments{}
+	for i, color := range q.histColors {
+		m.add(color, q.histCounts[i])
+	}
+	m.accumulate()
+	q.boxes = m.quantize(q.freeColors())
+	q.setResult(append(palette.Palette{}, q.boxes...))
+}
+
+// WuKMeans refines palette calculated by Wu's quantizer with k-means.
+type WuKMeans struct {
+	*PalCalc
+
+	wu *Wu
+}
+
+func NewWuKMeans(colors int, steps int) *WuKMeans {
+	km := NewPalCalc(colors, steps, 1)
+	return &WuKMeans{
+		PalCalc: km,
+		wu:      &Wu{base: base{colors: colors, reporter: km.reporter}},
+	}
+}
+
+func (q *WuKMeans) SetReporter(reporter Reporter) {
+	q.PalCalc.SetReporter(reporter)
+	q.wu.reporter = reporter
+}
+
+func (q *WuKMeans) SetFixed(fixed []FixedColor) {
+	q.PalCalc.SetFixed(fixed)
+	q.wu.SetFixed(fixed)
+}
+
+func (q *WuKMeans) SetHistogramBits(bits int) {
+	q.wu.bits = bits
+}
+
+func (q *WuKMeans) SetWeights(weights []float64) {
+	q.wu.SetWeights(weights)
+}
+
+func (q *WuKMeans) SetMasks(masks [][]uint8) {
+	q.wu.SetMasks(masks)
+}
+
+func (q *WuKMeans) Input(images [][]palette.IntColor) error {
+	if err := q.wu.Input(images); err != nil {
+		return err
+	}
+	q.PalCalc.colors = q.wu.colors
+	q.PalCalc.setPoints(q.wu.histColors, q.wu.histCounts)
+	return nil
+}
+
+func (q *WuKMeans) Run() {
+	q.RunContext(context.Background())
+}
+
+func (q *WuKMeans) RunContext(ctx context.Context) error {
+	if err := q.wu.RunContext(ctx); err != nil {
+		return err
+	}
+	q.PalCalc.SetInitial(q.wu.boxes)
+	return q.PalCalc.RunContext(ctx)
+}
@@ -0,0 +1,512 @@
+package txs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+const (
+	Magic         = "MK3T"
+	VersionLegacy = 0
+	Version       = 3
+)
+
+const (
+	FlagTransparency = 1 << iota
+)
+
+const (
+	EntryTransparent = 1 << iota
+)
+
+const (
+	txsNameSize   = 16
+	txsHeaderSize = 16
+	txsEntrySize  = 40
+)
+
+type Texture struct {
+	Name        string
+	Width       int
+	Height      int
+	Transparent int
+	Data        []uint8
+}
+
+type Entry struct {
+	Name        string
+	Offset      int64
+	Size        int64
+	Width       int
+	Height      int
+	Flags       uint32
+	Transparent int
+}
+
+type File struct {
+	Version   int
+	Flags     uint16
+	Palette   palette.Palette
+	Offset    int
+	Textures  []Texture
+	Directory []Entry
+}
+
+type txsHeader struct {
+	Magic    [4]byte
+	Version  uint16
+	Flags    uint16
+	Size     uint32
+	Textures uint32
+}
+
+type txsEntry struct {
+	Name        [txsNameSize]byte
+	Offset      uint32
+	Size        uint32
+	Width       uint32
+	Height      uint32
+	Flags       uint32
+	Transparent int16
+	Reserved    uint16
+}
+
+type txsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (tw *txsWriter) write(data any) {
+	if tw.err != nil {
+		return
+	}
+	tw.err = binary.Write(tw.w, binary.LittleEndian, data)
+}
+
+func (tw *txsWriter) writePalette(file *File) {
+	if file.Version >= 2 {
+		tw.write(uint16(file.Palette.Len()))
+	} else {
+		tw.write(uint8(file.Palette.Len()))
+	}
+	tw.write(uint8(file.Offset))
+	for _, color := range file.Palette {
+		tw.write([3]uint8{uint8(color.R), uint8(color.G), uint8(color.B)})
+	}
+}
+
+func (tw *txsWriter) writeTexture(tex *Texture) {
+	var name [txsNameSize]byte
+	copy(name[:], []byte(tex.Name))
+	tw.write(name)
+	tw.write(uint32(tex.Width))
+	tw.write(uint32(tex.Height))
+	tw.write(int16(tex.Transparent))
+	tw.write(tex.Data)
+}
+
+func (tw *txsWriter) writeEntry(tex *Texture, offset int) {
+	entry := txsEntry{
+		Offset:      uint32(offset),
+		Size:        uint32(len(tex.Data)),
+		Width:       uint32(tex.Width),
+		Height:      uint32(tex.Height),
+		Transparent: int16(tex.Transparent),
+	}
+	copy(entry.Name[:], []byte(tex.Name))
+	if tex.Transparent >= 0 {
+		entry.Flags |= EntryTransparent
+	}
+	tw.write(entry)
+}
+
+func (file *File) calcFlags() uint16 {
+	flags := file.Flags &^ FlagTransparency
+	for _, tex := range file.Textures {
+		if tex.Transparent >= 0 {
+			flags |= FlagTransparency
+			break
+		}
+	}
+	return flags
+}
+
+func (file *File) validatePalette() error {
+	colors := file.Palette.Len()
+	if colors < 1 || colors > 256 {
+		return fmt.Errorf("wrong number of colors %d (must be 1..256)", colors)
+	}
+	if file.Offset < 0 || colors+file.Offset > 256 {
+		return fmt.Errorf("wrong palette offset (%d+%d>256)", colors, file.Offset)
+	}
+	if file.Version < 2 && colors > 255 {
+		return fmt.Errorf("%d colors can not be stored in format version %d (maximum is 255)", colors, file.Version)
+	}
+	for i, c := range file.Palette {
+		if c.R < 0 || c.R > 255 || c.G < 0 || c.G > 255 || c.B < 0 || c.B > 255 {
+			return fmt.Errorf("palette color %d (%d, %d, %d) is out of 0..255", i, c.R, c.G, c.B)
+		}
+	}
+	return nil
+}
+
+func Write(w io.Writer, file *File) error {
+	if err := file.validatePalette(); err != nil {
+		return err
+	}
+	for _, tex := range file.Textures {
+		if len(tex.Data) != tex.Width*tex.Height {
+			return fmt.Errorf("texture \"%s\": data size %d does not match %dx%d", tex.Name, len(tex.Data), tex.Width, tex.Height)
+		}
+	}
+
+	switch file.Version {
+	case VersionLegacy:
+		tw := &txsWriter{w: w}
+		tw.writePalette(file)
+		tw.write(uint32(len(file.Textures)))
+		for i := range file.Textures {
+			tw.writeTexture(&file.Textures[i])
+		}
+		return tw.err
+	case 1, 2, Version:
+		var body bytes.Buffer
+		tw := &txsWriter{w: &body}
+		tw.writePalette(file)
+		if file.Version >= 3 {
+			offset := txsHeaderSize + body.Len() + len(file.Textures)*txsEntrySize
+			for i := range file.Textures {
+				tw.writeEntry(&file.Textures[i], offset)
+				offset += len(file.Textures[i].Data)
+			}
+			for _, tex := range file.Textures {
+				tw.write(tex.Data)
+			}
+		} else {
+			for i := range file.Textures {
+				tw.writeTexture(&file.Textures[i])
+			}
+		}
+		if tw.err != nil {
+			return tw.err
+		}
+		if uint64(body.Len())+txsHeaderSize > 0xFFFFFFFF {
+			return fmt.Errorf("file is too big (%d bytes)", body.Len()+txsHeaderSize)
+		}
+
+		header := txsHeader{
+			Version:  uint16(file.Version),
+			Flags:    file.calcFlags(),
+			Size:     uint32(body.Len() + txsHeaderSize),
+			Textures: uint32(len(file.Textures)),
+		}
+		copy(header.Magic[:], Magic)
+		tw = &txsWriter{w: w}
+		tw.write(header)
+		tw.write(body.Bytes())
+		return tw.err
+	default:
+		return fmt.Errorf("unsupported format version %d", file.Version)
+	}
+}
+
+type txsReader struct {
+	r    io.Reader
+	err  error
+	read int64
+}
+
+func (tr *txsReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	tr.read += int64(n)
+	return n, err
+}
+
+func (tr *txsReader) readData(data any) {
+	if tr.err != nil {
+		return
+	}
+	tr.err = binary.Read(tr, binary.LittleEndian, data)
+	if tr.err == io.EOF {
+		tr.err = io.ErrUnexpectedEOF
+	}
+}
+
+// readBytes reads size bytes. Memory grows with data actually read, so wrong
+// sizes in headers can not cause huge allocations.
+func (tr *txsReader) readBytes(size int64) []uint8 {
+	if tr.err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, size))
+	if err == nil && int64(len(data)) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	tr.err = err
+	return data
+}
+
+func (tr *txsReader) readPalette(file *File) error {
+	var colors int
+	if file.Version >= 2 {
+		var count uint16
+		tr.readData(&count)
+		colors = int(count)
+	} else {
+		var count uint8
+		tr.readData(&count)
+		colors = int(count)
+	}
+	var offset uint8
+	tr.readData(&offset)
+	if tr.err != nil {
+		return fmt.Errorf("reading palette header: %w", tr.err)
+	}
+	if colors > 256 || colors+int(offset) > 256 {
+		return fmt.Errorf("wrong palette header (%d colors, offset %d)", colors, offset)
+	}
+	file.Offset = int(offset)
+	file.Palette = palette.New(int(colors))
+	for i := range file.Palette {
+		var rgb [3]uint8
+		tr.readData(&rgb)
+		file.Palette[i] = palette.IntColor{R: int(rgb[0]), G: int(rgb[1]), B: int(rgb[2])}
+	}
+	if tr.err != nil {
+		return fmt.Errorf("reading palette: %w", tr.err)
+	}
+	return nil
+}
+
+func (tr *txsReader) readTexture(index int) (Texture, error) {
+	var (
+		name          [txsNameSize]byte
+		width, height uint32
+		transparent   int16
+	)
+	tr.readData(&name)
+	tr.readData(&width)
+	tr.readData(&height)
+	tr.readData(&transparent)
+	if tr.err != nil {
+		return Texture{}, fmt.Errorf("reading texture %d header: %w", index, tr.err)
+	}
+	tex := Texture{
+		Name:        txsName(name),
+		Width:       int(width),
+		Height:      int(height),
+		Transparent: int(transparent),
+	}
+	if uint64(width)*uint64(height) > 1<<31 {
+		return Texture{}, fmt.Errorf("texture \"%s\" is too big (%dx%d)", tex.Name, width, height)
+	}
+	tex.Data = tr.readBytes(int64(width) * int64(height))
+	if tr.err != nil {
+		return Texture{}, fmt.Errorf("reading texture \"%s\": %w", tex.Name, tr.err)
+	}
+	return tex, nil
+}
+
+func Read(r io.Reader) (*File, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(Magic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(magic) == Magic {
+		return readVersioned(&txsReader{r: br})
+	}
+	return readLegacy(&txsReader{r: br})
+}
+
+func readLegacy(tr *txsReader) (*File, error) {
+	result := &File{Version: VersionLegacy}
+	if err := tr.readPalette(result); err != nil {
+		return nil, err
+	}
+
+	var count uint32
+	tr.readData(&count)
+	if tr.err != nil {
+		return nil, fmt.Errorf("reading texture count: %w", tr.err)
+	}
+	result.Textures = make([]Texture, 0)
+	for i := 0; i < int(count); i++ {
+		tex, err := tr.readTexture(i)
+		if err != nil {
+			return nil, err
+		}
+		result.Textures = append(result.Textures, tex)
+	}
+	result.Flags = result.calcFlags()
+	return result, nil
+}
+
+func (tr *txsReader) readHeader() (txsHeader, error) {
+	var header txsHeader
+	tr.readData(&header)
+	if tr.err != nil {
+		return header, fmt.Errorf("reading header: %w", tr.err)
+	}
+	if string(header.Magic[:]) != Magic {
+		return header, fmt.Errorf("wrong file signature")
+	}
+	if header.Version < 1 || header.Version > Version {
+		return header, fmt.Errorf("unsupported format version %d", header.Version)
+	}
+	return header, nil
+}
+
+func (tr *txsReader) readDirectory(count int) ([]Entry, error) {
+	result := make([]Entry, 0)
+	for i := 0; i < count; i++ {
+		var entry txsEntry
+		tr.readData(&entry)
+		if tr.err != nil {
+			return nil, fmt.Errorf("reading directory entry %d: %w", i, tr.err)
+		}
+		result = append(result, Entry{
+			Name:        txsName(entry.Name),
+			Offset:      int64(entry.Offset),
+			Size:        int64(entry.Size),
+			Width:       int(entry.Width),
+			Height:      int(entry.Height),
+			Flags:       entry.Flags,
+			Transparent: int(entry.Transparent),
+		})
+	}
+	return result, nil
+}
+
+func validateDirectory(directory []Entry, dataStart int64, fileSize int64) error {
+	sorted := make([]Entry, len(directory))
+	copy(sorted, directory)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	end := dataStart
+	for _, entry := range sorted {
+		if entry.Size != int64(entry.Width)*int64(entry.Height) {
+			return fmt.Errorf("texture \"%s\": size %d does not match %dx%d", entry.Name, entry.Size, entry.Width, entry.Height)
+		}
+		if entry.Offset < end {
+			return fmt.Errorf("texture \"%s\": offset %d overlaps previous data", entry.Name, entry.Offset)
+		}
+		if entry.Offset+entry.Size > fileSize {
+			return fmt.Errorf("texture \"%s\": data at %d+%d is outside of file (%d bytes)", entry.Name, entry.Offset, entry.Size, fileSize)
+		}
+		end = entry.Offset + entry.Size
+	}
+	return nil
+}
+
+func (entry *Entry) texture(data []uint8) Texture {
+	transparent := -1
+	if entry.Flags&EntryTransparent != 0 {
+		transparent = entry.Transparent
+	}
+	return Texture{
+		Name:        entry.Name,
+		Width:       entry.Width,
+		Height:      entry.Height,
+		Transparent: transparent,
+		Data:        data,
+	}
+}
+
+func readVersioned(tr *txsReader) (*File, error) {
+	header, err := tr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &File{Version: int(header.Version), Flags: header.Flags}
+	if err := tr.readPalette(result); err != nil {
+		return nil, err
+	}
+	result.Textures = make([]Texture, 0)
+	if result.Version >= 3 {
+		result.Directory, err = tr.readDirectory(int(header.Textures))
+		if err != nil {
+			return nil, err
+		}
+		if err := validateDirectory(result.Directory, tr.read, int64(header.Size)); err != nil {
+			return nil, err
+		}
+		dataStart := tr.read
+		if int64(header.Size) < dataStart {
+			return nil, fmt.Errorf("file size mismatch: header says %d bytes, directory ends at %d", header.Size, dataStart)
+		}
+		data := tr.readBytes(int64(header.Size) - dataStart)
+		if tr.err != nil {
+			return nil, fmt.Errorf("reading texture data: %w", tr.err)
+		}
+		for i := range result.Directory {
+			entry := &result.Directory[i]
+			start := entry.Offset - dataStart
+			result.Textures = append(result.Textures, entry.texture(data[start:start+entry.Size]))
+		}
+	} else {
+		for i := 0; i < int(header.Textures); i++ {
+			tex, err := tr.readTexture(i)
+			if err != nil {
+				return nil, err
+			}
+			result.Textures = append(result.Textures, tex)
+		}
+	}
+	if tr.read != int64(header.Size) {
+		return nil, fmt.Errorf("file size mismatch: header says %d bytes, read %d", header.Size, tr.read)
+	}
+	return result, nil
+}
+
+// ReadTexture reads a single texture using the directory, without reading other textures.
+func ReadTexture(r io.ReaderAt, name string) (*Texture, error) {
+	tr := &txsReader{r: io.NewSectionReader(r, 0, math.MaxInt64)}
+	header, err := tr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if header.Version < 3 {
+		return nil, fmt.Errorf("format version %d has no texture directory", header.Version)
+	}
+	file := &File{Version: int(header.Version)}
+	if err := tr.readPalette(file); err != nil {
+		return nil, err
+	}
+	directory, err := tr.readDirectory(int(header.Textures))
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDirectory(directory, tr.read, int64(header.Size)); err != nil {
+		return nil, err
+	}
+	for i := range directory {
+		entry := &directory[i]
+		if entry.Name != name {
+			continue
+		}
+		data := &txsReader{r: io.NewSectionReader(r, entry.Offset, entry.Size)}
+		tex := entry.texture(data.readBytes(entry.Size))
+		if data.err != nil {
+			return nil, fmt.Errorf("reading texture \"%s\": %w", name, data.err)
+		}
+		return &tex, nil
+	}
+	return nil, fmt.Errorf("texture \"%s\" not found", name)
+}
+
+func txsName(name [txsNameSize]byte) string {
+	length := 0
+	for length < len(name) && name[length] != 0 {
+		length++
+	}
+	return string(name[:length])
+}
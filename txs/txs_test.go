@@ -0,0 +1,139 @@
+package txs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"git.defsub.dev/conan/mk3-tex.git/palette"
+)
+
+func TestReadMalformed(t *testing.T) {
+	header := func(size uint32, textures uint32) []byte {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, txsHeader{
+			Magic:    [4]byte{'M', 'K', '3', 'T'},
+			Version:  3,
+			Size:     size,
+			Textures: textures,
+		})
+		// empty palette
+		buf.Write([]byte{0, 0, 0})
+		return buf.Bytes()
+	}
+	legacy := []byte{0, 0, 1, 0, 0, 0}
+	legacy = append(legacy, make([]byte, txsNameSize)...)
+	legacy = append(legacy, 0xFF, 0xFF, 0, 0, 0xFF, 0x7F, 0, 0, 0xFF, 0xFF)
+
+	tests := map[string][]byte{
+		"size below header":   append(header(4, 0), 0),
+		"size above data":     header(0xFFFFFFFF, 0),
+		"too many textures":   header(19, 0xFFFFFFFF),
+		"huge legacy texture": legacy,
+	}
+	for name, data := range tests {
+		if _, err := Read(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := ReadTexture(bytes.NewReader(header(0xFFFFFFFF, 0)), "a"); err == nil {
+		t.Errorf("ReadTexture: no error")
+	}
+}
+
+func testPalette(colors int) palette.Palette {
+	pal := palette.New(colors)
+	for i := range pal {
+		pal[i] = palette.IntColor{R: i, G: 255 - i, B: i / 3}
+	}
+	return pal
+}
+
+func TestPaletteBoundaries(t *testing.T) {
+	for _, version := range []int{VersionLegacy, 1, 2, Version} {
+		for _, colors := range []int{1, 255, 256} {
+			for _, offset := range []int{0, 1, 255} {
+				file := &File{Version: version, Palette: testPalette(colors), Offset: offset}
+				valid := colors+offset <= 256 && (version >= 2 || colors <= 255)
+				var buf bytes.Buffer
+				err := Write(&buf, file)
+				if !valid {
+					if err == nil {
+						t.Errorf("version %d, %d colors at %d: no error", version, colors, offset)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("version %d, %d colors at %d: %v", version, colors, offset, err)
+					continue
+				}
+				read, err := Read(&buf)
+				if err != nil {
+					t.Errorf("version %d, %d colors at %d: reading: %v", version, colors, offset, err)
+					continue
+				}
+				if read.Offset != offset || len(read.Palette) != colors {
+					t.Errorf("version %d, %d colors at %d: read %d colors at %d", version, colors, offset, len(read.Palette), read.Offset)
+					continue
+				}
+				for i := range read.Palette {
+					if read.Palette[i] != file.Palette[i] {
+						t.Errorf("version %d, %d colors at %d: color %d is %v, want %v", version, colors, offset, i, read.Palette[i], file.Palette[i])
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	textures := []Texture{
+		{Name: "opaque", Width: 3, Height: 2, Transparent: -1, Data: []uint8{0, 1, 2, 3, 4, 5}},
+		{Name: "sixteen-bytes-xx", Width: 1, Height: 4, Transparent: 7, Data: []uint8{7, 7, 8, 9}},
+		{Name: "t", Width: 2, Height: 2, Transparent: 0, Data: []uint8{0, 9, 9, 0}},
+	}
+	for _, version := range []int{VersionLegacy, 1, 2, Version} {
+		file := &File{Version: version, Palette: testPalette(10), Offset: 5, Textures: textures}
+		var buf bytes.Buffer
+		if err := Write(&buf, file); err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		data := buf.Bytes()
+		read, err := Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("version %d: reading: %v", version, err)
+		}
+		if read.Version != version || read.Flags != FlagTransparency {
+			t.Errorf("version %d: read version %d, flags %d", version, read.Version, read.Flags)
+		}
+		if len(read.Textures) != len(textures) {
+			t.Fatalf("version %d: read %d textures, want %d", version, len(read.Textures), len(textures))
+		}
+		for i, want := range textures {
+			got := read.Textures[i]
+			if got.Name != want.Name || got.Width != want.Width || got.Height != want.Height ||
+				got.Transparent != want.Transparent || !bytes.Equal(got.Data, want.Data) {
+				t.Errorf("version %d: texture %d is %+v, want %+v", version, i, got, want)
+			}
+			if version < 3 {
+				continue
+			}
+			single, err := ReadTexture(bytes.NewReader(data), want.Name)
+			if err != nil {
+				t.Errorf("version %d: ReadTexture(%s): %v", version, want.Name, err)
+			} else if single.Transparent != want.Transparent || !bytes.Equal(single.Data, want.Data) {
+				t.Errorf("version %d: ReadTexture(%s) is %+v, want %+v", version, want.Name, *single, want)
+			}
+		}
+	}
+}
+
+func TestWriteInvalidColor(t *testing.T) {
+	for _, c := range []palette.IntColor{{R: 300}, {G: -5}, {B: 1000}} {
+		file := &File{Version: Version, Palette: palette.Palette{{}, c}}
+		if err := Write(&bytes.Buffer{}, file); err == nil {
+			t.Errorf("color %v: no error", c)
+		}
+	}
+}